@@ -8,8 +8,11 @@ require (
 	// github.com/axgle/mahonia v0.0.0-20180208002826-3358181d7394
 	github.com/emersion/go-imap v1.0.6
 	github.com/emersion/go-message v0.14.1
+	github.com/go-git/go-billy/v5 v5.0.0
 	github.com/go-git/go-git/v5 v5.2.0
 	github.com/jordan-wright/email v4.0.1-0.20210109023952-943e75fe5223+incompatible
 	github.com/otiai10/copy v1.6.0
 	github.com/studio-b12/gowebdav v0.0.0-20210203212356-8244b5a5f51a
+	golang.org/x/crypto v0.0.0-20200302210943-78000ba7a073
+	golang.org/x/net v0.0.0-20200301022130-244492dfa37a
 )
@@ -27,6 +27,11 @@ func InitAES() ([]byte, []byte, error) {
 
 // aes-256-gcm 加密
 func EncryptWithAES(aesKey []byte, nonce []byte, plaintext []byte) ([]byte, error) {
+	return EncryptWithAESAndAdditionalData(aesKey, nonce, plaintext, nil)
+}
+
+// aes-256-gcm 加密,并对不加密的附加数据进行认证
+func EncryptWithAESAndAdditionalData(aesKey []byte, nonce []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
 	block, err := aes.NewCipher(aesKey)
 	if err != nil {
 		fmt.Println("无法生成AES block", err)
@@ -37,13 +42,18 @@ func EncryptWithAES(aesKey []byte, nonce []byte, plaintext []byte) ([]byte, erro
 		fmt.Println("无法生成AEAD对象", err)
 		return nil, err
 	}
-	ciphertext := aesgcm.Seal(nil, nonce, plaintext, nil)
+	ciphertext := aesgcm.Seal(nil, nonce, plaintext, additionalData)
 	return ciphertext, err
 
 }
 
 // aes-256-gcm 解密
 func DecryptWithAES(aesKey []byte, nonce []byte, ciphertext []byte) ([]byte, error) {
+	return DecryptWithAESAndAdditionalData(aesKey, nonce, ciphertext, nil)
+}
+
+// aes-256-gcm 解密,并验证附加数据
+func DecryptWithAESAndAdditionalData(aesKey []byte, nonce []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
 	block, err := aes.NewCipher(aesKey)
 	if err != nil {
 		fmt.Println("无法生成AES block", err)
@@ -54,7 +64,7 @@ func DecryptWithAES(aesKey []byte, nonce []byte, ciphertext []byte) ([]byte, err
 		fmt.Println("无法生成AEAD对象", err)
 		return nil, err
 	}
-	plaintext, err := aesgcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := aesgcm.Open(nil, nonce, ciphertext, additionalData)
 	if err != nil {
 		fmt.Println("无法使用AES解密", err)
 		return nil, err
@@ -0,0 +1,183 @@
+// 洋葱式的路由信封,替代原来只用接入节点公钥加密接收方代号的附带文件.
+//  发送方按照路由从内到外逐层加密,每一层只能被对应的节点用自己的私钥打开.
+//  每一层包含下一跳/过期时间/随机填充,最内层包含最终接收方的代号,信封中不包含发送方的任何信息.
+//  每一层的格式为: RSA加密的(对称密钥+Nonce) | aes-256-gcm加密的层内容(以RSA密文作为附加数据进行认证).
+package envelope
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+	"xindauserbackground/src/crypto/aestools"
+	"xindauserbackground/src/crypto/rsatools"
+	"xindauserbackground/src/jsontools"
+)
+
+// 信封的默认有效期
+const DefaultLifetime = 7 * 24 * time.Hour
+
+// 路由中最多允许的节点数量
+const MaxHopNum = 8
+
+// 每一层默认的最大随机填充长度
+const DefaultMaxPaddingSize = 256
+
+// 路由上的一个节点
+type Hop struct {
+	Name      string         // 节点的代号
+	PublicKey *rsa.PublicKey // 节点的公钥
+}
+
+// 打开一层信封之后得到的内容
+type Layer struct {
+	ReceiverName string // 最终接收方的代号,只有最内层才有
+	NextHop      string // 下一跳节点的代号,最内层为空
+	Expiry       int64  // 过期时间(Unix时间戳)
+	HopLimit     int    // 还允许被转发的次数,最内层为0
+	Inner        []byte // 留给下一跳的信封,最内层为空
+}
+
+// 判断这一层是不是最内层,即本节点就是最终的接入节点
+func (l Layer) IsFinal() bool {
+	return l.NextHop == ""
+}
+
+// 生成随机长度的填充
+func generatePadding(maxPaddingSize int) []byte {
+	if maxPaddingSize <= 0 {
+		return []byte{}
+	}
+	n, _ := rand.Int(rand.Reader, big.NewInt(int64(maxPaddingSize)))
+	padding := make([]byte, int(n.Int64()))
+	io.ReadFull(rand.Reader, padding)
+	return padding
+}
+
+// 用节点的公钥加密一层信封
+func sealLayer(layer Layer, maxPaddingSize int, pub *rsa.PublicKey) ([]byte, error) {
+	layerParser := jsontools.GenerateNewJsonParser()
+	layerParser.SetValue(layer.ReceiverName, "ReceiverName")
+	layerParser.SetValue(layer.NextHop, "NextHop")
+	layerParser.SetValue(layer.Expiry, "Expiry")
+	layerParser.SetValue(layer.HopLimit, "HopLimit")
+	layerParser.SetValue(rsatools.BytesToHexString(layer.Inner), "Inner")
+	layerParser.SetValue(rsatools.BytesToHexString(generatePadding(maxPaddingSize)), "Padding")
+	aesKey, nonce, err := aestools.InitAES()
+	if err != nil {
+		return nil, err
+	}
+	encryptedKeyBytes, err := rsatools.EncryptWithPublicKey(append(aesKey, nonce...), pub)
+	if err != nil {
+		return nil, err
+	}
+	encryptedLayerBytes, err := aestools.EncryptWithAESAndAdditionalData(aesKey, nonce, layerParser.Parser.Bytes(), encryptedKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return append(encryptedKeyBytes, encryptedLayerBytes...), nil
+}
+
+// 根据路由从内到外逐层生成信封,hopList[0]为第一个接入节点,最后一个节点负责把数据交换文件交给接收方
+func Seal(receiverName string, hopList []Hop, lifetime time.Duration, maxPaddingSize int) ([]byte, error) {
+	var err error
+	if len(hopList) == 0 || len(hopList) > MaxHopNum {
+		err = fmt.Errorf("路由中的节点数量必须在1到%d之间", MaxHopNum)
+		fmt.Println(err)
+		return nil, err
+	}
+	expiry := time.Now().Add(lifetime).Unix()
+	var inner []byte
+	for i := len(hopList) - 1; i >= 0; i-- {
+		layer := Layer{Expiry: expiry, HopLimit: len(hopList) - 1 - i, Inner: inner}
+		if i == len(hopList)-1 {
+			layer.ReceiverName = receiverName
+		} else {
+			layer.NextHop = hopList[i+1].Name
+		}
+		inner, err = sealLayer(layer, maxPaddingSize, hopList[i].PublicKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return inner, nil
+}
+
+// 用本节点的私钥打开最外层信封,并检查是否已经过期
+func Open(envelopeBytes []byte, priv *rsa.PrivateKey) (Layer, error) {
+	var err error
+	encryptedKeyLength := (priv.N.BitLen() + 7) / 8 // 对称密钥和Nonce只占一个RSA分块
+	if len(envelopeBytes) <= encryptedKeyLength {
+		err = fmt.Errorf("信封长度不合法")
+		return Layer{}, err
+	}
+	encryptedKeyBytes := envelopeBytes[:encryptedKeyLength]
+	keyBytes, err := rsatools.TryDecryptWithPrivateKey(encryptedKeyBytes, priv)
+	if err != nil || len(keyBytes) != 256/8+12 {
+		err = fmt.Errorf("无法用本节点的私钥打开信封")
+		return Layer{}, err
+	}
+	layerBytes, err := aestools.DecryptWithAESAndAdditionalData(keyBytes[:256/8], keyBytes[256/8:], envelopeBytes[encryptedKeyLength:], encryptedKeyBytes)
+	if err != nil {
+		return Layer{}, err
+	}
+	layerParser, err := jsontools.ReadJsonBytes(layerBytes)
+	if err != nil {
+		return Layer{}, err
+	}
+	// 任何知道本节点公钥的人都可以生成信封,每个字段都要检查类型
+	innerString, isInnerOk := layerParser.ReadJsonValue("/Inner").(string)
+	receiverName, isReceiverNameOk := layerParser.ReadJsonValue("/ReceiverName").(string)
+	nextHop, isNextHopOk := layerParser.ReadJsonValue("/NextHop").(string)
+	expiry, isExpiryOk := layerParser.ReadJsonValue("/Expiry").(float64)
+	hopLimit, isHopLimitOk := layerParser.ReadJsonValue("/HopLimit").(float64)
+	if !isInnerOk || !isReceiverNameOk || !isNextHopOk || !isExpiryOk || !isHopLimitOk {
+		err = fmt.Errorf("信封的格式不合法")
+		fmt.Println(err)
+		return Layer{}, err
+	}
+	inner, err := rsatools.HexStringToBytes(innerString)
+	if err != nil {
+		return Layer{}, err
+	}
+	layer := Layer{
+		ReceiverName: receiverName,
+		NextHop:      nextHop,
+		Expiry:       int64(expiry),
+		HopLimit:     int(hopLimit),
+		Inner:        inner,
+	}
+	if layer.IsFinal() && layer.ReceiverName == "" {
+		err = fmt.Errorf("信封的最内层没有接收方")
+		fmt.Println(err)
+		return Layer{}, err
+	}
+	if !layer.IsFinal() && (layer.HopLimit <= 0 || layer.HopLimit >= MaxHopNum) {
+		err = fmt.Errorf("信封的转发次数超出限制")
+		fmt.Println(err)
+		return Layer{}, err
+	}
+	if time.Now().Unix() > layer.Expiry {
+		err = fmt.Errorf("信封已经过期")
+		fmt.Println(err)
+		return Layer{}, err
+	}
+	return layer, nil
+}
+
+// 估计信封的最大长度,用于上传前检查IFSS账号的大小限制.
+//  每一层包含RSA密文/GCM认证标签/JSON的键名和数字,以及16进制表示的内层信封和填充
+func EstimateMaxSize(receiverName string, hopList []Hop, maxPaddingSize int) int {
+	const layerJsonOverhead = 256 // JSON的键名/过期时间/转发次数等
+	var size int
+	for i := len(hopList) - 1; i >= 0; i-- {
+		nameLength := len(receiverName)
+		if i != len(hopList)-1 {
+			nameLength = len(hopList[i+1].Name)
+		}
+		size = hopList[i].PublicKey.Size() + 16 + layerJsonOverhead + nameLength + 2*size + 2*maxPaddingSize
+	}
+	return size
+}
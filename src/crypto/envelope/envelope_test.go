@@ -0,0 +1,140 @@
+package envelope
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"testing"
+	"time"
+	"xindauserbackground/src/crypto/rsatools"
+)
+
+// 生成测试用的节点及其私钥
+func newTestHopList(t *testing.T, hopNum int) ([]Hop, []*rsa.PrivateKey) {
+	var hopList []Hop
+	var privList []*rsa.PrivateKey
+	for i := 0; i < hopNum; i++ {
+		pub, priv, err := rsatools.GenerateKeyPair(1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hopList = append(hopList, Hop{Name: fmt.Sprint("hop", i), PublicKey: pub})
+		privList = append(privList, priv)
+	}
+	return hopList, privList
+}
+
+func TestSealOpen(t *testing.T) {
+	testCaseList := []struct {
+		name           string
+		hopNum         int
+		receiverName   string
+		maxPaddingSize int
+	}{
+		{"单个节点", 1, "bob", DefaultMaxPaddingSize},
+		{"多个节点", 3, "bob", DefaultMaxPaddingSize},
+		{"不填充", 2, "bob", 0},
+		{"多个接收方", 2, "bob,carol", 16},
+		{"最多的节点", MaxHopNum, "bob", 8},
+	}
+	for _, testCase := range testCaseList {
+		t.Run(testCase.name, func(t *testing.T) {
+			hopList, privList := newTestHopList(t, testCase.hopNum)
+			envelopeBytes, err := Seal(testCase.receiverName, hopList, DefaultLifetime, testCase.maxPaddingSize)
+			if err != nil {
+				t.Fatalf("生成信封失败: %v", err)
+			}
+			if maxSize := EstimateMaxSize(testCase.receiverName, hopList, testCase.maxPaddingSize); len(envelopeBytes) > maxSize {
+				t.Errorf("信封长度%d超过了估计的最大长度%d", len(envelopeBytes), maxSize)
+			}
+			// 逐个节点打开信封
+			for i, priv := range privList {
+				layer, err := Open(envelopeBytes, priv)
+				if err != nil {
+					t.Fatalf("节点%d打开信封失败: %v", i, err)
+				}
+				if layer.HopLimit != testCase.hopNum-1-i {
+					t.Errorf("节点%d的转发次数为%d,应为%d", i, layer.HopLimit, testCase.hopNum-1-i)
+				}
+				if i == testCase.hopNum-1 {
+					if !layer.IsFinal() || layer.ReceiverName != testCase.receiverName || len(layer.Inner) != 0 {
+						t.Errorf("最内层的内容不正确: %+v", layer)
+					}
+					break
+				}
+				if layer.IsFinal() || layer.NextHop != hopList[i+1].Name || layer.ReceiverName != "" {
+					t.Fatalf("节点%d的下一跳为%q,应为%q", i, layer.NextHop, hopList[i+1].Name)
+				}
+				// 中间节点不能打开内层信封
+				if _, err := Open(layer.Inner, priv); err == nil {
+					t.Errorf("节点%d打开了不属于自己的内层信封", i)
+				}
+				envelopeBytes = layer.Inner
+			}
+		})
+	}
+}
+
+func TestSealInvalidHopList(t *testing.T) {
+	testCaseList := []struct {
+		name   string
+		hopNum int
+	}{
+		{"没有节点", 0},
+		{"节点过多", MaxHopNum + 1},
+	}
+	for _, testCase := range testCaseList {
+		t.Run(testCase.name, func(t *testing.T) {
+			hopList := make([]Hop, testCase.hopNum)
+			if _, err := Seal("bob", hopList, DefaultLifetime, 0); err == nil {
+				t.Errorf("%d个节点时应当返回错误", testCase.hopNum)
+			}
+		})
+	}
+}
+
+func TestOpenMalformed(t *testing.T) {
+	hopList, privList := newTestHopList(t, 2)
+	envelopeBytes, err := Seal("bob", hopList[:1], DefaultLifetime, DefaultMaxPaddingSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedKeyLength := privList[0].PublicKey.Size()
+	// 生成自定义内容的一层信封,模拟知道节点公钥的攻击者
+	sealTestLayer := func(layer Layer) []byte {
+		layerBytes, err := sealLayer(layer, 0, hopList[0].PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return layerBytes
+	}
+	tamper := func(index int) []byte {
+		tamperedBytes := append([]byte{}, envelopeBytes...)
+		tamperedBytes[index] ^= 0x01
+		return tamperedBytes
+	}
+	expiry := time.Now().Add(time.Hour).Unix()
+	testCaseList := []struct {
+		name          string
+		envelopeBytes []byte
+		priv          *rsa.PrivateKey
+	}{
+		{"空信封", []byte{}, privList[0]},
+		{"只有RSA密文", envelopeBytes[:encryptedKeyLength], privList[0]},
+		{"截断的信封", envelopeBytes[:len(envelopeBytes)-1], privList[0]},
+		{"篡改RSA密文", tamper(0), privList[0]},
+		{"篡改层内容", tamper(encryptedKeyLength + 1), privList[0]},
+		{"篡改认证标签", tamper(len(envelopeBytes) - 1), privList[0]},
+		{"其他节点的私钥", envelopeBytes, privList[1]},
+		{"已经过期", sealTestLayer(Layer{ReceiverName: "bob", Expiry: time.Now().Add(-time.Hour).Unix()}), privList[0]},
+		{"最内层没有接收方", sealTestLayer(Layer{Expiry: expiry}), privList[0]},
+		{"转发次数为0", sealTestLayer(Layer{NextHop: "hop1", Expiry: expiry}), privList[0]},
+		{"转发次数过多", sealTestLayer(Layer{NextHop: "hop1", Expiry: expiry, HopLimit: MaxHopNum}), privList[0]},
+	}
+	for _, testCase := range testCaseList {
+		t.Run(testCase.name, func(t *testing.T) {
+			if layer, err := Open(testCase.envelopeBytes, testCase.priv); err == nil {
+				t.Errorf("应当返回错误,实际打开得到%+v", layer)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+// 共享同一身份的组(团队)的组密钥生成与分发.
+//  用户列表中的GroupList记录每个组的组公钥和成员列表,组私钥分别用每个成员的公钥加密后存放在成员列表中.
+//  发送方可以把组名作为ReceiverName,用组公钥加密;每个成员用自己的私钥解出组私钥后即可下载并还原.
+package grouptools
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"xindauserbackground/src/crypto/rsatools"
+	"xindauserbackground/src/jsontools"
+)
+
+// 为一个组生成新的组密钥对,并用每个成员的公钥加密组私钥,写入用户列表的GroupList中.
+//  如果组已经存在,则替换为新的组密钥(例如成员变更后需要重新分发)
+func GenerateGroup(userListJsonPath, groupName string, memberNameList []string, bits int) error {
+	var err error
+	userListParser, err := jsontools.ReadJsonFile(userListJsonPath)
+	if err != nil {
+		return err
+	}
+	groupPublicKey, groupPrivateKey, err := rsatools.GenerateKeyPair(bits)
+	if err != nil {
+		return err
+	}
+	groupPublicKeyString, err := rsatools.PublicKeyToString(groupPublicKey)
+	if err != nil {
+		return err
+	}
+	groupPrivateKeyString, err := rsatools.PrivateKeyToString(groupPrivateKey)
+	if err != nil {
+		return err
+	}
+	group := jsontools.GenerateNewJsonParser()
+	group.SetValue(groupName, "Name")
+	group.SetValue(groupPublicKeyString, "PublicKey")
+	group.SetArray("MemberList")
+	for _, memberName := range memberNameList {
+		var memberPublicKeyString string
+		for _, children := range userListParser.GetAllChildren("UserList") {
+			if children.ReadJsonValue("/Name").(string) == memberName {
+				memberPublicKeyString = children.ReadJsonValue("/PublicKey").(string)
+				break
+			}
+		}
+		if memberPublicKeyString == "" {
+			err = fmt.Errorf("无法在用户列表中找到组成员%s的公钥", memberName)
+			fmt.Println(err)
+			return err
+		}
+		memberPublicKey, err := rsatools.StringToPublicKey(memberPublicKeyString)
+		if err != nil {
+			return err
+		}
+		encryptedGroupPrivateKey, err := rsatools.EncryptWithPublicKey([]byte(groupPrivateKeyString), memberPublicKey)
+		if err != nil {
+			return err
+		}
+		member := jsontools.GenerateNewJsonParser()
+		member.SetValue(memberName, "Name")
+		member.SetValue(rsatools.BytesToHexString(encryptedGroupPrivateKey), "EncryptedPrivateKey")
+		group.AppendArray(member.Parser.Data(), "MemberList")
+	}
+	// 替换掉同名的旧组
+	oldGroupList := userListParser.GetAllChildren("GroupList")
+	userListParser.SetArray("GroupList")
+	for _, children := range oldGroupList {
+		if children.ReadJsonValue("/Name").(string) == groupName {
+			continue
+		}
+		userListParser.AppendArray(children.Parser.Data(), "GroupList")
+	}
+	userListParser.AppendArray(group.Parser.Data(), "GroupList")
+	return userListParser.WriteJsonFile(userListJsonPath)
+}
+
+// 在用户列表中找到组公钥字符串,找不到则返回空字符串
+func FindGroupPublicKeyString(userListParser *jsontools.JsonParser, groupName string) string {
+	for _, children := range userListParser.GetAllChildren("GroupList") {
+		if children.ReadJsonValue("/Name").(string) == groupName {
+			return children.ReadJsonValue("/PublicKey").(string)
+		}
+	}
+	return ""
+}
+
+// 用本方私钥解出本方所属的所有组的组私钥,返回组名对应组私钥的Map
+func ReadGroupPrivateKeyMap(userListParser *jsontools.JsonParser, userPrivateKey *rsa.PrivateKey) map[string]*rsa.PrivateKey {
+	groupName_PrivateKeyMap := make(map[string]*rsa.PrivateKey)
+	for _, group := range userListParser.GetAllChildren("GroupList") {
+		groupName := group.ReadJsonValue("/Name").(string)
+		for _, member := range group.GetAllChildren("MemberList") {
+			encryptedGroupPrivateKey, err := rsatools.HexStringToBytes(member.ReadJsonValue("/EncryptedPrivateKey").(string))
+			if err != nil {
+				continue
+			}
+			// 只有本方是组成员时才能解密成功
+			groupPrivateKeyBytes, err := rsatools.TryDecryptWithPrivateKey(encryptedGroupPrivateKey, userPrivateKey)
+			if err != nil {
+				continue
+			}
+			groupPrivateKey, err := rsatools.StringToPrivateKey(string(groupPrivateKeyBytes))
+			if err != nil {
+				continue
+			}
+			groupName_PrivateKeyMap[groupName] = groupPrivateKey
+			break
+		}
+	}
+	return groupName_PrivateKeyMap
+}
@@ -0,0 +1,148 @@
+// 联系人公钥的首次使用即信任(TOFU)固定.
+//  第一次见到某个联系人的公钥时记录其指纹,之后如果用户列表中该联系人的公钥发生了变化,则给出警告,直到用户确认接受新的公钥.
+package keypin
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"xindauserbackground/src/crypto/rsatools"
+	"xindauserbackground/src/filetools"
+	"xindauserbackground/src/jsontools"
+)
+
+type PinState string
+
+// 公钥固定的三种状态
+const (
+	PIN_NEW     PinState = "new"     // 第一次见到该联系人,已记录其指纹
+	PIN_MATCHED PinState = "matched" // 公钥与记录的指纹一致
+	PIN_CHANGED PinState = "changed" // 公钥与记录的指纹不一致,需要用户线下核对
+)
+
+// 多个还原任务会并行检查和记录指纹,读取-修改-写入指纹记录时需要持有这个锁
+var pinStoreMutex sync.Mutex
+
+// 读取指纹记录文件,如果不存在就返回一个空的记录
+func readPinStore(pinStorePath string) (*jsontools.JsonParser, error) {
+	if !filetools.IsPathExists(pinStorePath) {
+		pinStoreParser := jsontools.GenerateNewJsonParser()
+		pinStoreParser.SetArray("PinList")
+		return pinStoreParser, nil
+	}
+	return jsontools.ReadJsonFile(pinStorePath)
+}
+
+// 在指纹记录中找到联系人记录的指纹,没有记录则返回空字符串
+func findPinnedFingerprint(pinStoreParser *jsontools.JsonParser, name string) string {
+	for _, children := range pinStoreParser.GetAllChildren("PinList") {
+		if pinnedName, _ := children.ReadJsonValue("/Name").(string); pinnedName == name {
+			fingerprint, _ := children.ReadJsonValue("/Fingerprint").(string)
+			return fingerprint
+		}
+	}
+	return ""
+}
+
+// 将联系人的指纹写入指纹记录,覆盖之前的记录.
+//  先写入临时文件再重命名,写入过程中出错不会破坏原有的记录
+func writePin(pinStorePath string, pinStoreParser *jsontools.JsonParser, name, fingerprint string) error {
+	newPinStoreParser := jsontools.GenerateNewJsonParser()
+	newPinStoreParser.SetArray("PinList")
+	for _, children := range pinStoreParser.GetAllChildren("PinList") {
+		if pinnedName, _ := children.ReadJsonValue("/Name").(string); pinnedName == name {
+			continue
+		}
+		newPinStoreParser.AppendArray(children.Parser.Data(), "PinList")
+	}
+	pin := jsontools.GenerateNewJsonParser()
+	pin.SetValue(name, "Name")
+	pin.SetValue(fingerprint, "Fingerprint")
+	pin.SetValue(time.Now().Unix(), "PinnedTime")
+	newPinStoreParser.AppendArray(pin.Parser.Data(), "PinList")
+	tempPinStorePath := pinStorePath + ".tmp"
+	err := newPinStoreParser.WriteJsonFile(tempPinStorePath)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tempPinStorePath, pinStorePath)
+	if err != nil {
+		fmt.Println("无法替换指纹记录文件", err)
+		return err
+	}
+	return nil
+}
+
+// 检查联系人的公钥是否与记录的指纹一致,第一次见到该联系人时记录其指纹
+func CheckAndPin(pinStorePath, name string, pub *rsa.PublicKey) (PinState, string, error) {
+	fingerprintBytes, err := rsatools.GetPublicKeyFingerprint(pub)
+	if err != nil {
+		return "", "", err
+	}
+	fingerprint := rsatools.FingerprintToHexString(fingerprintBytes)
+	pinStoreMutex.Lock()
+	defer pinStoreMutex.Unlock()
+	pinStoreParser, err := readPinStore(pinStorePath)
+	if err != nil {
+		return "", fingerprint, err
+	}
+	pinnedFingerprint := findPinnedFingerprint(pinStoreParser, name)
+	if pinnedFingerprint == "" {
+		err = writePin(pinStorePath, pinStoreParser, name, fingerprint)
+		if err != nil {
+			return "", fingerprint, err
+		}
+		fmt.Println("第一次见到", name, "的公钥,已记录其指纹", fingerprint, "请线下核对", rsatools.FingerprintToWords(fingerprintBytes))
+		return PIN_NEW, fingerprint, nil
+	}
+	if pinnedFingerprint != fingerprint {
+		fmt.Println("警告:", name, "的公钥发生了变化,记录的指纹为", pinnedFingerprint, "当前的指纹为", fingerprint, "请线下核对", rsatools.FingerprintToEmoji(fingerprintBytes))
+		return PIN_CHANGED, fingerprint, nil
+	}
+	return PIN_MATCHED, fingerprint, nil
+}
+
+// 检查公钥字符串,第一次见到该联系人时记录其指纹
+func CheckAndPinString(pinStorePath, name, pubString string) (PinState, string, error) {
+	if pubString == "" {
+		err := fmt.Errorf("无法在用户列表中找到%s的公钥", name)
+		fmt.Println(err)
+		return "", "", err
+	}
+	pub, err := rsatools.StringToPublicKey(pubString)
+	if err != nil {
+		return "", "", err
+	}
+	return CheckAndPin(pinStorePath, name, pub)
+}
+
+// 用户线下核对通过后,接受联系人的新公钥,用新指纹替换记录的指纹
+func AcceptKeyChange(pinStorePath, name string, pub *rsa.PublicKey) error {
+	fingerprintBytes, err := rsatools.GetPublicKeyFingerprint(pub)
+	if err != nil {
+		return err
+	}
+	pinStoreMutex.Lock()
+	defer pinStoreMutex.Unlock()
+	pinStoreParser, err := readPinStore(pinStorePath)
+	if err != nil {
+		return err
+	}
+	return writePin(pinStorePath, pinStoreParser, name, rsatools.FingerprintToHexString(fingerprintBytes))
+}
+
+// 用户线下核对通过后,接受用户列表中联系人的新公钥字符串
+func AcceptKeyChangeString(pinStorePath, name, pubString string) error {
+	if pubString == "" {
+		err := fmt.Errorf("无法在用户列表中找到%s的公钥", name)
+		fmt.Println(err)
+		return err
+	}
+	pub, err := rsatools.StringToPublicKey(pubString)
+	if err != nil {
+		return err
+	}
+	return AcceptKeyChange(pinStorePath, name, pub)
+}
@@ -0,0 +1,119 @@
+package rsatools
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// 指纹转为单词/emoji时,每个符号代表的位数
+const fingerprintSymbolBits = 6
+
+// 指纹转为单词/emoji时使用的符号数量(共96位)
+const fingerprintSymbolNum = 16
+
+// 64个易读易念的单词,每个单词代表6位
+var fingerprintWordList = [64]string{
+	"acid", "apple", "arrow", "atlas", "bacon", "bamboo", "banjo", "basil",
+	"beach", "berry", "bison", "blade", "brick", "cabin", "camel", "candy",
+	"canoe", "cedar", "chalk", "cider", "cliff", "cobra", "comet", "coral",
+	"crane", "daisy", "delta", "denim", "eagle", "ember", "fable", "falcon",
+	"fern", "flint", "fox", "galaxy", "garlic", "ginger", "glacier", "grape",
+	"harbor", "hazel", "igloo", "ivory", "jade", "jelly", "kayak", "koala",
+	"lemon", "lotus", "magnet", "maple", "meadow", "nectar", "oasis", "olive",
+	"panda", "pepper", "quartz", "raven", "salmon", "tiger", "violet", "walnut",
+}
+
+// 64个容易区分的emoji,每个emoji代表6位
+var fingerprintEmojiList = [64]string{
+	"🐶", "🐱", "🐭", "🐹", "🐰", "🦊", "🐻", "🐼",
+	"🐨", "🐯", "🦁", "🐮", "🐷", "🐸", "🐵", "🐔",
+	"🐧", "🐦", "🦆", "🦉", "🐴", "🦄", "🐝", "🐛",
+	"🦋", "🐌", "🐞", "🐢", "🐍", "🐙", "🦀", "🐬",
+	"🐳", "🦈", "🐊", "🐘", "🦒", "🌵", "🌲", "🌻",
+	"🍄", "🌙", "⭐", "🔥", "🌈", "⛄", "🍎", "🍋",
+	"🍉", "🍇", "🍓", "🍒", "🥕", "🌽", "🍩", "🎈",
+	"🎸", "🚀", "🚲", "⚓", "🔑", "🔔", "💎", "🎲",
+}
+
+// 计算公钥的SHA-256指纹(对公钥的DER编码做哈希)
+func GetPublicKeyFingerprint(pub *rsa.PublicKey) ([]byte, error) {
+	pubASN1, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		fmt.Println("无法计算公钥指纹", err)
+		return nil, err
+	}
+	fingerprint := sha256.Sum256(pubASN1)
+	return fingerprint[:], nil
+}
+
+// 将指纹转为以冒号分隔的16进制字符串,如"AB:CD:..."
+func FingerprintToHexString(fingerprint []byte) string {
+	hexList := make([]string, len(fingerprint))
+	for i, b := range fingerprint {
+		hexList[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hexList, ":")
+}
+
+// 按顺序从指纹中每次取出6位,作为单词/emoji列表的下标
+func fingerprintToSymbolIndexList(fingerprint []byte) []int {
+	var indexList []int
+	var buffer, bufferBits uint
+	for _, b := range fingerprint {
+		buffer = buffer<<8 | uint(b)
+		bufferBits += 8
+		for bufferBits >= fingerprintSymbolBits && len(indexList) < fingerprintSymbolNum {
+			bufferBits -= fingerprintSymbolBits
+			indexList = append(indexList, int(buffer>>bufferBits)&(1<<fingerprintSymbolBits-1))
+		}
+		buffer &= 1<<bufferBits - 1
+	}
+	return indexList
+}
+
+// 将指纹的前96位转为便于口头核对的单词序列
+func FingerprintToWords(fingerprint []byte) string {
+	var wordList []string
+	for _, index := range fingerprintToSymbolIndexList(fingerprint) {
+		wordList = append(wordList, fingerprintWordList[index])
+	}
+	return strings.Join(wordList, " ")
+}
+
+// 将指纹的前96位转为便于肉眼核对的emoji序列
+func FingerprintToEmoji(fingerprint []byte) string {
+	var emojiList []string
+	for _, index := range fingerprintToSymbolIndexList(fingerprint) {
+		emojiList = append(emojiList, fingerprintEmojiList[index])
+	}
+	return strings.Join(emojiList, "")
+}
+
+// 计算公钥字符串的指纹,并以16进制字符串表示
+func GetPublicKeyStringFingerprint(pubString string) (string, error) {
+	pub, err := StringToPublicKey(pubString)
+	if err != nil {
+		return "", err
+	}
+	fingerprint, err := GetPublicKeyFingerprint(pub)
+	if err != nil {
+		return "", err
+	}
+	return FingerprintToHexString(fingerprint), nil
+}
+
+// 计算公钥字符串的指纹,并以emoji序列表示
+func GetPublicKeyStringFingerprintEmoji(pubString string) (string, error) {
+	pub, err := StringToPublicKey(pubString)
+	if err != nil {
+		return "", err
+	}
+	fingerprint, err := GetPublicKeyFingerprint(pub)
+	if err != nil {
+		return "", err
+	}
+	return FingerprintToEmoji(fingerprint), nil
+}
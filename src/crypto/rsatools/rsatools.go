@@ -74,6 +74,11 @@ func PublicKeyToString(pub *rsa.PublicKey) (string, error) {
 // 将bytes转为私钥
 func bytesToPrivateKey(priv []byte) (*rsa.PrivateKey, error) {
 	block, _ := pem.Decode(priv)
+	if block == nil {
+		err := fmt.Errorf("无法解析RSA私钥的PEM格式")
+		fmt.Println(err)
+		return nil, err
+	}
 	enc := x509.IsEncryptedPEMBlock(block)
 	b := block.Bytes
 	var err error
@@ -96,6 +101,11 @@ func bytesToPrivateKey(priv []byte) (*rsa.PrivateKey, error) {
 // 将bytes转为公钥
 func bytesToPublicKey(pub []byte) (*rsa.PublicKey, error) {
 	block, _ := pem.Decode(pub)
+	if block == nil {
+		err := fmt.Errorf("无法解析RSA公钥的PEM格式")
+		fmt.Println(err)
+		return nil, err
+	}
 	enc := x509.IsEncryptedPEMBlock(block)
 	b := block.Bytes
 	var err error
@@ -119,6 +129,12 @@ func bytesToPublicKey(pub []byte) (*rsa.PublicKey, error) {
 	return key, nil
 }
 
+// 将私钥结构体转为string
+func PrivateKeyToString(priv *rsa.PrivateKey) (string, error) {
+	privBytes, err := privateKeyToBytes(priv)
+	return bytes2str(privBytes), err
+}
+
 // 将string转为私钥
 func StringToPrivateKey(str string) (*rsa.PrivateKey, error) {
 	return bytesToPrivateKey(str2bytes(str))
@@ -238,13 +254,22 @@ func EncryptWithPublicKey(plaintext []byte, pub *rsa.PublicKey) ([]byte, error)
 
 // 将数据用私钥解密
 func DecryptWithPrivateKey(ciphertext []byte, priv *rsa.PrivateKey) ([]byte, error) {
+	plaintext, err := TryDecryptWithPrivateKey(ciphertext, priv)
+	if err != nil {
+		fmt.Println("无法将数据用RSA私钥解密", err)
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// 尝试将数据用私钥解密,失败时不输出错误信息(用于在多个密文/私钥中寻找能解密的一个)
+func TryDecryptWithPrivateKey(ciphertext []byte, priv *rsa.PrivateKey) ([]byte, error) {
 	partLen := priv.N.BitLen() / 8
 	chunks := split([]byte(ciphertext), partLen)
 	buffer := bytes.NewBufferString("")
 	for _, chunk := range chunks {
 		decrypted, err := rsa.DecryptPKCS1v15(rand.Reader, priv, chunk)
 		if err != nil {
-			fmt.Println("无法将数据用RSA私钥解密", err)
 			return nil, err
 		}
 		buffer.Write(decrypted)
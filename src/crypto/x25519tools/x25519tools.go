@@ -0,0 +1,286 @@
+// 基于X25519临时密钥交换的前向安全方法.
+//  接收方在用户列表中发布X25519交换公钥(ExchangePublicKey),交换私钥保存在密钥环文件夹中,与RSA私钥分开.
+//  发送方每次通信(每个Identification)生成一个临时X25519密钥对,与接收方交换公钥协商出共享密钥,再用HKDF为每个分片派生密钥.
+//  数据交换文件中只保存临时公钥和交换公钥的ID,即使之后接收方的RSA私钥泄露,也无法解密之前的通信内容.
+//  接收方用RotateKeyRing轮换交换密钥,被替换的交换私钥再保留RetiredKeyLifetime(等待路上的数据交换文件)后被覆盖并删除,
+//  之后即使密钥环泄露,也无法解密在这之前的通信内容.
+package x25519tools
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"xindauserbackground/src/filetools"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+var FilePermMode = os.FileMode(0777)           // Default file permission
+var PrivateKeyFilePermMode = os.FileMode(0600) // 私钥文件只允许所有者读写
+
+// X25519公钥和私钥的长度(字节)
+const KeySize = 32
+
+// 交换公钥ID的长度(字节),为公钥SHA-256的前8字节
+const KeyIDSize = 8
+
+// 被替换的交换私钥继续保留的时间,与路由信封的默认有效期相同,过期的数据交换文件本来就会被丢弃
+const RetiredKeyLifetime = 7 * 24 * time.Hour
+
+// 密钥环文件夹中交换私钥文件的后缀,文件名为密钥ID
+const PrivateKeyFileSuffix = ".key"
+
+// 密钥环文件夹中当前交换公钥的文件名,接收方把它发布到用户列表的ExchangePublicKey中
+const CurrentPublicKeyFileName = "exchange.pub"
+
+// 派生分片对称密钥时使用的上下文信息
+const fragmentKeyInfo = "xinda-fragment-key"
+
+// 生成X25519密钥对
+func GenerateKeyPair() ([]byte, []byte, error) {
+	priv := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, priv); err != nil {
+		fmt.Println("无法生成X25519私钥", err)
+		return nil, nil, err
+	}
+	pub, err := GetPublicKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pub, priv, nil
+}
+
+// 将以16进制表示的字符串转为X25519密钥
+func StringToKey(str string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(str))
+	if err != nil {
+		fmt.Println("无法解析X25519密钥", err)
+		return nil, err
+	}
+	if len(key) != KeySize {
+		err = fmt.Errorf("X25519密钥长度不合法")
+		fmt.Println(err)
+		return nil, err
+	}
+	return key, nil
+}
+
+// 读取私钥文件
+func ReadPrivateKeyFile(privateKeyFilePath string) ([]byte, error) {
+	privateKeyBytes, err := filetools.ReadFile(privateKeyFilePath)
+	if err != nil {
+		fmt.Println("无法读取X25519私钥文件", err)
+		return nil, err
+	}
+	return StringToKey(string(privateKeyBytes))
+}
+
+// 用本方私钥和对方公钥协商出共享密钥
+func ComputeSharedSecret(priv, pub []byte) ([]byte, error) {
+	sharedSecret, err := curve25519.X25519(priv, pub)
+	if err != nil {
+		fmt.Println("无法协商X25519共享密钥", err)
+		return nil, err
+	}
+	return sharedSecret, nil
+}
+
+// 根据共享密钥为某个分片派生256位的密钥,用来包装该分片的对称密钥.
+//  salt为临时公钥和接收方交换公钥的拼接,info中包含Identification/GroupSN/FragmentSN,保证每个分片的密钥都不相同
+func DeriveFragmentKey(sharedSecret, ephemeralPublicKey, receiverPublicKey []byte, identification int32, groupSN, fragmentSN int8) ([]byte, error) {
+	salt := append(append([]byte{}, ephemeralPublicKey...), receiverPublicKey...)
+	info := make([]byte, len(fragmentKeyInfo)+6)
+	copy(info, fragmentKeyInfo)
+	binary.BigEndian.PutUint32(info[len(fragmentKeyInfo):], uint32(identification))
+	info[len(fragmentKeyInfo)+4] = byte(groupSN)
+	info[len(fragmentKeyInfo)+5] = byte(fragmentSN)
+	fragmentKey := make([]byte, 256/8)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sharedSecret, salt, info), fragmentKey); err != nil {
+		fmt.Println("无法派生分片的对称密钥", err)
+		return nil, err
+	}
+	return fragmentKey, nil
+}
+
+// 用完之后清除内存中的密钥
+func WipeKey(key []byte) {
+	for i := range key {
+		key[i] = 0
+	}
+}
+
+// 根据私钥计算出对应的公钥
+func GetPublicKey(priv []byte) ([]byte, error) {
+	pub, err := curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		fmt.Println("无法根据X25519私钥计算公钥", err)
+		return nil, err
+	}
+	return pub, nil
+}
+
+// 计算交换公钥的ID,发送方写入数据交换文件,接收方据此在密钥环中找到对应的交换私钥
+func GetKeyID(pub []byte) []byte {
+	hash := sha256.Sum256(pub)
+	return hash[:KeyIDSize]
+}
+
+// 用派生出的密钥包装(或解开)分片的对称密钥,两者长度相同,每个派生密钥只使用一次
+func XorKey(key, derivedKey []byte) []byte {
+	result := make([]byte, len(key))
+	for i := range key {
+		result[i] = key[i] ^ derivedKey[i]
+	}
+	return result
+}
+
+// 接收方的交换密钥环,保存当前和最近被替换的交换私钥
+type KeyRing struct {
+	keyID_PrivateKeyMap map[string][]byte
+}
+
+// 密钥环中的一个交换私钥文件
+type keyFileInfo struct {
+	path       string
+	createTime time.Time
+}
+
+// 列出密钥环中的交换私钥文件,按生成时间从旧到新排序
+func listKeyFileList(keyRingDir string) ([]keyFileInfo, error) {
+	var keyFileList []keyFileInfo
+	if !filetools.IsPathExists(keyRingDir) {
+		return keyFileList, nil
+	}
+	filePathList, fileNameList, err := filetools.GenerateUnhiddenFilePathNameListFromFolder(keyRingDir)
+	if err != nil {
+		return nil, err
+	}
+	for i, filePath := range filePathList {
+		if !strings.HasSuffix(fileNameList[i], PrivateKeyFileSuffix) {
+			continue
+		}
+		fileInfo, err := os.Stat(filePath)
+		if err != nil {
+			return nil, err
+		}
+		keyFileList = append(keyFileList, keyFileInfo{filePath, fileInfo.ModTime()})
+	}
+	sort.Slice(keyFileList, func(i, j int) bool { return keyFileList[i].createTime.Before(keyFileList[j].createTime) })
+	return keyFileList, nil
+}
+
+// 覆盖交换私钥文件的内容后再删除
+func destroyPrivateKeyFile(privateKeyFilePath string) error {
+	fileInfo, err := os.Stat(privateKeyFilePath)
+	if err != nil {
+		return err
+	}
+	err = filetools.WriteFile(privateKeyFilePath, make([]byte, fileInfo.Size()), PrivateKeyFilePermMode)
+	if err != nil {
+		return err
+	}
+	return filetools.RmFile(privateKeyFilePath)
+}
+
+// 销毁被替换超过retiredKeyLifetime的交换私钥,最新的交换私钥不会被销毁.
+//  一个交换私钥在比它新的交换私钥生成时被替换
+func DestroyRetiredKeys(keyRingDir string, retiredKeyLifetime time.Duration) error {
+	keyFileList, err := listKeyFileList(keyRingDir)
+	if err != nil {
+		return err
+	}
+	for i := 0; i+1 < len(keyFileList); i++ {
+		retireTime := keyFileList[i+1].createTime
+		if time.Since(retireTime) < retiredKeyLifetime {
+			continue
+		}
+		err = destroyPrivateKeyFile(keyFileList[i].path)
+		if err != nil {
+			fmt.Println("无法销毁过期的X25519交换私钥", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// 轮换交换密钥:在密钥环中生成新的交换密钥对,并销毁被替换超过retiredKeyLifetime的交换私钥.
+//  返回新的交换公钥(16进制字符串),同时写入密钥环的CurrentPublicKeyFileName中,需要由接收方发布到用户列表
+func RotateKeyRing(keyRingDir string, retiredKeyLifetime time.Duration) (string, error) {
+	pub, priv, err := GenerateKeyPair()
+	if err != nil {
+		return "", err
+	}
+	defer WipeKey(priv)
+	privateKeyFilePath := filepath.Join(keyRingDir, hex.EncodeToString(GetKeyID(pub))+PrivateKeyFileSuffix)
+	err = filetools.WriteFile(privateKeyFilePath, []byte(hex.EncodeToString(priv)), PrivateKeyFilePermMode)
+	if err != nil {
+		fmt.Println("无法将X25519交换私钥写入密钥环", err)
+		return "", err
+	}
+	err = os.Chmod(keyRingDir, 0700)
+	if err != nil {
+		return "", err
+	}
+	publicKeyString := hex.EncodeToString(pub)
+	err = filetools.WriteFile(filepath.Join(keyRingDir, CurrentPublicKeyFileName), []byte(publicKeyString), FilePermMode)
+	if err != nil {
+		fmt.Println("无法将X25519交换公钥写入密钥环", err)
+		return "", err
+	}
+	err = DestroyRetiredKeys(keyRingDir, retiredKeyLifetime)
+	if err != nil {
+		return "", err
+	}
+	return publicKeyString, nil
+}
+
+// 读取密钥环中所有的交换私钥,每次还原只读取一次
+func ReadKeyRing(keyRingDir string) (*KeyRing, error) {
+	keyRing := &KeyRing{keyID_PrivateKeyMap: make(map[string][]byte)}
+	keyFileList, err := listKeyFileList(keyRingDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, keyFile := range keyFileList {
+		priv, err := ReadPrivateKeyFile(keyFile.path)
+		if err != nil {
+			keyRing.Wipe()
+			return nil, err
+		}
+		pub, err := GetPublicKey(priv)
+		if err != nil {
+			keyRing.Wipe()
+			return nil, err
+		}
+		keyRing.keyID_PrivateKeyMap[string(GetKeyID(pub))] = priv
+	}
+	return keyRing, nil
+}
+
+// 根据交换公钥的ID找到对应的交换私钥,找不到说明它已经被销毁
+func (k *KeyRing) FindPrivateKey(keyID []byte) ([]byte, error) {
+	priv, isExist := k.keyID_PrivateKeyMap[string(keyID)]
+	if !isExist {
+		err := fmt.Errorf("密钥环中没有ID为%x的交换私钥,它可能已经被销毁", keyID)
+		fmt.Println(err)
+		return nil, err
+	}
+	return priv, nil
+}
+
+// 用完之后清除内存中的所有交换私钥
+func (k *KeyRing) Wipe() {
+	for keyID, priv := range k.keyID_PrivateKeyMap {
+		WipeKey(priv)
+		delete(k.keyID_PrivateKeyMap, keyID)
+	}
+}
@@ -3,6 +3,9 @@ package filetools
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
 	"fmt"
 	"io/ioutil"
 	"math/big"
@@ -40,6 +43,29 @@ func WriteFile(filePath string, data []byte, perm os.FileMode) error {
 	return err
 }
 
+// 计算文件内容的SHA-256,并以16进制字符串表示
+func GetFileHash(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		fmt.Println("无法打开文件", filePath, err)
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		fmt.Println("无法读取文件", filePath, err)
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// 计算内存中数据的SHA-256,和GetFileHash的结果一致
+func GetBytesHash(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
 // 读文件
 func ReadFile(filePath string) ([]byte, error) {
 	bytes, err := ioutil.ReadFile(filePath)
@@ -278,3 +304,17 @@ func DivideDirListToGroup(dirList []string, groupNum int) [][]string {
 	}
 	return DirGroup
 }
+
+// 数据交换文件使用的随机文件名的长度
+const RandomFileNameLength = 9
+
+// 生成由大小写字母和数字组成的随机文件名
+func GenerateRandomFileName(length int) string {
+	var seed = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
+	var fileName string
+	for i := 0; i < length; i++ {
+		randomInt, _ := rand.Int(rand.Reader, big.NewInt(int64(len(seed))))
+		fileName += string(seed[randomInt.Int64()])
+	}
+	return fileName
+}
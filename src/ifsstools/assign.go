@@ -0,0 +1,219 @@
+package ifsstools
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"xindauserbackground/src/filetools"
+	"xindauserbackground/src/jsontools"
+	"xindauserbackground/src/specfile"
+)
+
+// 数据交换文件分配给IFSS账号的策略
+const (
+	ASSIGN_RANDOM       = "random"       // 每个文件均匀随机地分配给一个账号
+	ASSIGN_WEIGHTED     = "weighted"     // 按照账号的Weight(带宽/配额)加权随机分配
+	ASSIGN_GROUP_SPREAD = "group_spread" // 在加权随机的基础上,同一组的文件分配给不同的账号
+)
+
+// 分配策略
+type assignStrategy struct {
+	Type              string
+	MinIFSSTypeNum    int               // 每一组的文件至少要分布在几种IFSS类型上
+	ReplicationFactor int               // 每个数据交换文件上传到几个不同的账号
+	fileName_GroupMap map[string]string // 数据交换文件名对应所属组
+}
+
+// 从发送阶段配置和发送方本地的清单中读取分配策略,没有配置时均匀随机分配
+func readAssignStrategy(sendStrategyJsonParser *jsontools.JsonParser, sendFolderDir string) (assignStrategy, error) {
+	var strategy assignStrategy
+	if sendStrategyJsonParser == nil {
+		return strategy, nil
+	}
+	if sendStrategyJsonParser.IsPathExists("/AssignStrategy") {
+		strategy.Type = sendStrategyJsonParser.ReadJsonValue("/AssignStrategy").(string)
+	}
+	strategy.MinIFSSTypeNum = readIntValue(sendStrategyJsonParser, "/MinIFSSTypeNum", 0)
+	strategy.ReplicationFactor = readIntValue(sendStrategyJsonParser, "/ReplicationFactor", 1)
+	if strategy.Type == ASSIGN_GROUP_SPREAD {
+		fileName_GroupMap, err := specfile.ReadManifestGroupMap(sendFolderDir)
+		if err != nil {
+			return strategy, err
+		}
+		strategy.fileName_GroupMap = fileName_GroupMap
+	}
+	return strategy, nil
+}
+
+// 读取账号的权重,没有配置时为1
+func readAccountWeight(accountParser *jsontools.JsonParser) float64 {
+	if accountParser.IsPathExists("/Weight") {
+		return accountParser.ReadJsonValue("/Weight").(float64)
+	}
+	return 1
+}
+
+// 在候选账号中按照权重随机选择一个
+func chooseWeightedAccount(candidateList []int, weightList []float64) int {
+	var totalWeight float64
+	for _, i := range candidateList {
+		totalWeight += weightList[i]
+	}
+	if totalWeight <= 0 { // 权重都为0时均匀随机
+		n, _ := rand.Int(rand.Reader, big.NewInt(int64(len(candidateList))))
+		return candidateList[n.Int64()]
+	}
+	const precision = 1 << 30
+	n, _ := rand.Int(rand.Reader, big.NewInt(precision))
+	target := float64(n.Int64()) / precision * totalWeight
+	for _, i := range candidateList {
+		target -= weightList[i]
+		if target < 0 {
+			return i
+		}
+	}
+	return candidateList[len(candidateList)-1]
+}
+
+// 根据分配策略将数据交换文件分配给各个IFSS账号,返回每个账号对应的文件路径列表,以及副本文件名对应的原文件名.
+//  filePath_UploadSizeMap为每个文件打包后的估计大小,用来检查账号的大小限制;
+//  filePath_ForbiddenSetMap记录每个文件不能分配到的账号(accountParserList中的下标),例如已经保存了这个文件其他副本的账号,
+//  同一个文件的多个副本共用同一个集合,分配后选中的账号会被加入集合,使这些副本分配到不同的账号;
+//  复制因子大于1时,为每个文件生成使用新随机文件名的副本,分配给还没有这个文件的账号,分配失败时删除已经生成的副本
+func assignFileListToAccountList(filePathList []string, accountParserList []*jsontools.JsonParser, strategy assignStrategy, filePath_UploadSizeMap map[string]int64, filePath_ForbiddenSetMap map[string]map[int]bool) ([][]string, map[string]string, error) {
+	var err error
+	var weightList []float64
+	var limitList []accountLimit
+	var ifssTypeList []string
+	var hasLimit bool
+	ifssTypeSet := make(map[string]bool)
+	for _, accountParser := range accountParserList {
+		weightList = append(weightList, readAccountWeight(accountParser))
+		limit := readAccountLimit(accountParser)
+		limitList = append(limitList, limit)
+		hasLimit = hasLimit || limit.isLimited()
+		ifssType := accountParser.ReadJsonValue("/IFSSType").(string)
+		ifssTypeList = append(ifssTypeList, ifssType)
+		ifssTypeSet[ifssType] = true
+	}
+	switch strategy.Type {
+	case "", ASSIGN_RANDOM:
+		if !hasLimit && strategy.ReplicationFactor <= 1 && len(filePath_ForbiddenSetMap) == 0 {
+			return filetools.DivideDirListToGroup(filePathList, len(accountParserList)), nil, err
+		}
+		for i := range weightList { // 有大小限制、需要复制或者有禁止的账号时,仍然均匀随机,只是跳过不能使用的账号
+			weightList[i] = 1
+		}
+	case ASSIGN_WEIGHTED, ASSIGN_GROUP_SPREAD:
+	default:
+		err = fmt.Errorf("分配策略%s不合法", strategy.Type)
+		fmt.Println(err)
+		return nil, nil, err
+	}
+	if len(ifssTypeSet) < strategy.MinIFSSTypeNum {
+		err = fmt.Errorf("IFSS账号只有%d种类型,无法满足至少%d种类型的要求", len(ifssTypeSet), strategy.MinIFSSTypeNum)
+		fmt.Println(err)
+		return nil, nil, err
+	}
+	if strategy.ReplicationFactor > len(accountParserList) {
+		err = fmt.Errorf("复制因子%d超过了IFSS账号的数量%d", strategy.ReplicationFactor, len(accountParserList))
+		fmt.Println(err)
+		return nil, nil, err
+	}
+	// 按组整理文件,ASSIGN_WEIGHTED或者清单中没有记录的文件各自成为一组
+	var groupList [][]string
+	group_IndexMap := make(map[string]int)
+	for _, filePath := range filePathList {
+		_, fileName := filepath.Split(filePath)
+		group, isExist := strategy.fileName_GroupMap[fileName]
+		if !isExist {
+			groupList = append(groupList, []string{filePath})
+			continue
+		}
+		if index, isExist := group_IndexMap[group]; isExist {
+			groupList[index] = append(groupList[index], filePath)
+		} else {
+			group_IndexMap[group] = len(groupList)
+			groupList = append(groupList, []string{filePath})
+		}
+	}
+	filePathGroup := make([][]string, len(accountParserList))
+	usedSizeList := make([]int64, len(accountParserList))
+	// 在能放下这个文件且不在forbiddenSet中的账号中选择;优先选择groupUsedSet之外的账号,
+	// 组内还没有达到最少的IFSS类型数量时,再优先选择组内还没有用过的类型
+	chooseAccount := func(uploadSize int64, forbiddenSet, groupUsedSet map[int]bool, ifssTypeUsedSet map[string]bool) (int, bool) {
+		var candidateList, groupUsedCandidateList, newTypeCandidateList []int
+		for i, limit := range limitList {
+			if forbiddenSet[i] || !limit.canHold(uploadSize, usedSizeList[i]) {
+				continue
+			}
+			if groupUsedSet[i] {
+				groupUsedCandidateList = append(groupUsedCandidateList, i)
+				continue
+			}
+			candidateList = append(candidateList, i)
+			if !ifssTypeUsedSet[ifssTypeList[i]] {
+				newTypeCandidateList = append(newTypeCandidateList, i)
+			}
+		}
+		if len(ifssTypeUsedSet) < strategy.MinIFSSTypeNum && len(newTypeCandidateList) > 0 {
+			candidateList = newTypeCandidateList
+		}
+		if len(candidateList) == 0 { // 组内文件数多于账号数时,只能重复使用组内用过的账号
+			candidateList = groupUsedCandidateList
+		}
+		if len(candidateList) == 0 {
+			return 0, false
+		}
+		return chooseWeightedAccount(candidateList, weightList), true
+	}
+	fileName_OriginMap := make(map[string]string)
+	var replicaFilePathList []string
+	// 分配失败时删除已经生成的副本,以免它们留在发送文件夹中
+	removeReplicaFileList := func() {
+		for _, replicaFilePath := range replicaFilePathList {
+			if filetools.IsPathExists(replicaFilePath) {
+				filetools.RmFile(replicaFilePath)
+			}
+		}
+	}
+	for _, groupFilePathList := range groupList {
+		groupUsedSet := make(map[int]bool)
+		ifssTypeUsedSet := make(map[string]bool)
+		for _, filePath := range groupFilePathList {
+			uploadSize := filePath_UploadSizeMap[filePath]
+			// 副本必须放在与原文件以及其他副本不同的账号上
+			replicaUsedSet, isExist := filePath_ForbiddenSetMap[filePath]
+			if !isExist {
+				replicaUsedSet = make(map[int]bool)
+			}
+			for r := 0; r < strategy.ReplicationFactor || r == 0; r++ {
+				chosen, isOk := chooseAccount(uploadSize, replicaUsedSet, groupUsedSet, ifssTypeUsedSet)
+				if !isOk {
+					err = fmt.Errorf("没有足够的IFSS账号容纳数据交换文件%s(约%d字节)", filepath.Base(filePath), uploadSize)
+					fmt.Println(err)
+					removeReplicaFileList()
+					return nil, nil, err
+				}
+				replicaFilePath := filePath
+				if r > 0 {
+					replicaFilePath = filepath.Join(filepath.Dir(filePath), filetools.GenerateRandomFileName(filetools.RandomFileNameLength))
+					replicaFilePathList = append(replicaFilePathList, replicaFilePath)
+					err = filetools.Copy(filePath, replicaFilePath)
+					if err != nil {
+						removeReplicaFileList()
+						return nil, nil, err
+					}
+					fileName_OriginMap[filepath.Base(replicaFilePath)] = filepath.Base(filePath)
+				}
+				filePathGroup[chosen] = append(filePathGroup[chosen], replicaFilePath)
+				usedSizeList[chosen] += uploadSize
+				replicaUsedSet[chosen] = true
+				groupUsedSet[chosen] = true
+				ifssTypeUsedSet[ifssTypeList[chosen]] = true
+			}
+		}
+	}
+	return filePathGroup, fileName_OriginMap, err
+}
@@ -0,0 +1,237 @@
+package ifsstools
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"xindauserbackground/src/jsontools"
+)
+
+// 生成测试用的账号列表
+func newTestAccountParserList(t *testing.T, accountJsonList []string) []*jsontools.JsonParser {
+	var accountParserList []*jsontools.JsonParser
+	for i, accountJson := range accountJsonList {
+		accountParser, err := jsontools.ReadJsonString(accountJson)
+		if err != nil {
+			t.Fatalf("无法读取账号%d的配置: %v", i, err)
+		}
+		if !accountParser.IsPathExists("/IFSSName") {
+			accountParser.SetValue(fmt.Sprint("account", i), "IFSSName")
+		}
+		accountParserList = append(accountParserList, accountParser)
+	}
+	return accountParserList
+}
+
+// 在临时文件夹中生成fileNum个数据交换文件
+func newTestFilePathList(t *testing.T, fileNum int) []string {
+	dir := t.TempDir()
+	var filePathList []string
+	for i := 0; i < fileNum; i++ {
+		filePath := filepath.Join(dir, fmt.Sprint("file", i))
+		if err := ioutil.WriteFile(filePath, []byte(filePath), 0644); err != nil {
+			t.Fatal(err)
+		}
+		filePathList = append(filePathList, filePath)
+	}
+	return filePathList
+}
+
+func TestAssignFileListToAccountList(t *testing.T) {
+	testCaseList := []struct {
+		name              string
+		accountJsonList   []string
+		strategy          assignStrategy
+		fileNum           int
+		fileName_GroupMap map[string]string
+		uploadSize        int64
+		forbiddenList     []int // 所有文件都不能分配到的账号
+		emptyAccountList  []int // 不应分配到文件的账号
+		spreadGroup       bool  // 同一组的文件是否应当分配到不同的账号
+		wantErr           bool
+	}{
+		{
+			name:            "均匀随机",
+			accountJsonList: []string{`{"IFSSType": "git"}`, `{"IFSSType": "webdav"}`},
+			fileNum:         6,
+		},
+		{
+			name:             "权重为0的账号不分配",
+			accountJsonList:  []string{`{"IFSSType": "git", "Weight": 0}`, `{"IFSSType": "webdav", "Weight": 3}`},
+			strategy:         assignStrategy{Type: ASSIGN_WEIGHTED},
+			fileNum:          20,
+			emptyAccountList: []int{0},
+		},
+		{
+			name:            "同一组分配到不同账号",
+			accountJsonList: []string{`{"IFSSType": "git"}`, `{"IFSSType": "git", "Weight": 100}`, `{"IFSSType": "webdav"}`},
+			strategy:        assignStrategy{Type: ASSIGN_GROUP_SPREAD, MinIFSSTypeNum: 2},
+			fileNum:         6,
+			fileName_GroupMap: map[string]string{
+				"file0": "0", "file1": "0", "file2": "0",
+				"file3": "1", "file4": "1", "file5": "1",
+			},
+			spreadGroup: true,
+		},
+		{
+			name:            "IFSS类型不够",
+			accountJsonList: []string{`{"IFSSType": "git"}`, `{"IFSSType": "git"}`},
+			strategy:        assignStrategy{Type: ASSIGN_GROUP_SPREAD, MinIFSSTypeNum: 2},
+			fileNum:         2,
+			wantErr:         true,
+		},
+		{
+			name:            "复制因子",
+			accountJsonList: []string{`{"IFSSType": "git"}`, `{"IFSSType": "webdav"}`, `{"IFSSType": "email"}`},
+			strategy:        assignStrategy{Type: ASSIGN_WEIGHTED, ReplicationFactor: 3},
+			fileNum:         4,
+		},
+		{
+			name:            "复制因子超过账号数量",
+			accountJsonList: []string{`{"IFSSType": "git"}`, `{"IFSSType": "webdav"}`},
+			strategy:        assignStrategy{Type: ASSIGN_RANDOM, ReplicationFactor: 3},
+			fileNum:         1,
+			wantErr:         true,
+		},
+		{
+			name:             "单个文件大小限制",
+			accountJsonList:  []string{`{"IFSSType": "email", "MaxFileSize": 100}`, `{"IFSSType": "git"}`},
+			fileNum:          5,
+			uploadSize:       200,
+			emptyAccountList: []int{0},
+		},
+		{
+			name:            "总大小限制放不下",
+			accountJsonList: []string{`{"IFSSType": "webdav", "MaxTotalSize": 500}`, `{"IFSSType": "webdav", "MaxTotalSize": 500}`},
+			strategy:        assignStrategy{Type: ASSIGN_WEIGHTED},
+			fileNum:         6,
+			uploadSize:      200,
+			wantErr:         true,
+		},
+		{
+			name:             "跳过禁止的账号",
+			accountJsonList:  []string{`{"IFSSType": "git"}`, `{"IFSSType": "webdav"}`},
+			fileNum:          4,
+			forbiddenList:    []int{1},
+			emptyAccountList: []int{1},
+		},
+		{
+			name:            "分配策略不合法",
+			accountJsonList: []string{`{"IFSSType": "git"}`},
+			strategy:        assignStrategy{Type: "unknown"},
+			fileNum:         1,
+			wantErr:         true,
+		},
+	}
+	for _, testCase := range testCaseList {
+		t.Run(testCase.name, func(t *testing.T) {
+			accountParserList := newTestAccountParserList(t, testCase.accountJsonList)
+			filePathList := newTestFilePathList(t, testCase.fileNum)
+			strategy := testCase.strategy
+			strategy.fileName_GroupMap = testCase.fileName_GroupMap
+			filePath_UploadSizeMap := make(map[string]int64)
+			filePath_ForbiddenSetMap := make(map[string]map[int]bool)
+			for _, filePath := range filePathList {
+				filePath_UploadSizeMap[filePath] = testCase.uploadSize
+				for _, forbidden := range testCase.forbiddenList {
+					if filePath_ForbiddenSetMap[filePath] == nil {
+						filePath_ForbiddenSetMap[filePath] = make(map[int]bool)
+					}
+					filePath_ForbiddenSetMap[filePath][forbidden] = true
+				}
+			}
+			filePathGroup, fileName_OriginMap, err := assignFileListToAccountList(filePathList, accountParserList, strategy, filePath_UploadSizeMap, filePath_ForbiddenSetMap)
+			if testCase.wantErr {
+				if err == nil {
+					t.Fatalf("应当返回错误,实际分配结果为%v", filePathGroup)
+				}
+				fileInfoList, _ := ioutil.ReadDir(filepath.Dir(filePathList[0]))
+				if len(fileInfoList) != testCase.fileNum {
+					t.Errorf("分配失败后发送文件夹中有%d个文件,应为%d个", len(fileInfoList), testCase.fileNum)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("返回了错误: %v", err)
+			}
+			if len(filePathGroup) != len(accountParserList) {
+				t.Fatalf("分配结果有%d组,应为%d组", len(filePathGroup), len(accountParserList))
+			}
+			replicationFactor := strategy.ReplicationFactor
+			if replicationFactor < 1 {
+				replicationFactor = 1
+			}
+			// 每个文件及其副本应当分配到replicationFactor个不同的账号
+			fileName_AccountSetMap := make(map[string]map[int]bool)
+			fileName_GroupAccountMap := make(map[string]int)
+			for i, accountFilePathList := range filePathGroup {
+				for _, filePath := range accountFilePathList {
+					fileName := filepath.Base(filePath)
+					if origin, isExist := fileName_OriginMap[fileName]; isExist {
+						fileName = origin
+					} else {
+						fileName_GroupAccountMap[fileName] = i
+					}
+					if fileName_AccountSetMap[fileName] == nil {
+						fileName_AccountSetMap[fileName] = make(map[int]bool)
+					}
+					if fileName_AccountSetMap[fileName][i] {
+						t.Errorf("文件%s的多个副本分配到了同一个账号%d", fileName, i)
+					}
+					fileName_AccountSetMap[fileName][i] = true
+				}
+			}
+			if len(fileName_AccountSetMap) != testCase.fileNum {
+				t.Errorf("分配了%d个文件,应为%d个", len(fileName_AccountSetMap), testCase.fileNum)
+			}
+			for fileName, accountSet := range fileName_AccountSetMap {
+				if len(accountSet) != replicationFactor {
+					t.Errorf("文件%s分配到了%d个账号,应为%d个", fileName, len(accountSet), replicationFactor)
+				}
+			}
+			for _, i := range testCase.emptyAccountList {
+				if len(filePathGroup[i]) > 0 {
+					t.Errorf("账号%d不应分配到文件,实际分配了%v", i, filePathGroup[i])
+				}
+			}
+			if testCase.spreadGroup {
+				group_AccountSetMap := make(map[string]map[int]bool)
+				for fileName, group := range testCase.fileName_GroupMap {
+					i := fileName_GroupAccountMap[fileName]
+					if group_AccountSetMap[group] == nil {
+						group_AccountSetMap[group] = make(map[int]bool)
+					}
+					if group_AccountSetMap[group][i] {
+						t.Errorf("组%s的多个文件分配到了同一个账号%d", group, i)
+					}
+					group_AccountSetMap[group][i] = true
+				}
+			}
+		})
+	}
+}
+
+func TestChooseWeightedAccount(t *testing.T) {
+	testCaseList := []struct {
+		name          string
+		candidateList []int
+		weightList    []float64
+		allowedSet    map[int]bool
+	}{
+		{"只有一个候选账号", []int{1}, []float64{5, 1, 5}, map[int]bool{1: true}},
+		{"跳过权重为0的账号", []int{0, 1, 2}, []float64{0, 2, 0}, map[int]bool{1: true}},
+		{"权重都为0时均匀随机", []int{0, 2}, []float64{0, 0, 0}, map[int]bool{0: true, 2: true}},
+		{"只在候选账号中选择", []int{0, 2}, []float64{1, 100, 1}, map[int]bool{0: true, 2: true}},
+	}
+	for _, testCase := range testCaseList {
+		t.Run(testCase.name, func(t *testing.T) {
+			for i := 0; i < 50; i++ {
+				chosen := chooseWeightedAccount(testCase.candidateList, testCase.weightList)
+				if !testCase.allowedSet[chosen] {
+					t.Fatalf("选择了账号%d,只能在%v中选择", chosen, testCase.allowedSet)
+				}
+			}
+		})
+	}
+}
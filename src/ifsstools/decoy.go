@@ -0,0 +1,342 @@
+package ifsstools
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math/big"
+	"path/filepath"
+	"sync"
+	"time"
+	"xindauserbackground/src/crypto/envelope"
+	"xindauserbackground/src/crypto/rsatools"
+	"xindauserbackground/src/filetools"
+	"xindauserbackground/src/jsontools"
+	"xindauserbackground/src/specfile"
+	"xindauserbackground/src/specfile/padding"
+)
+
+// 诱饵文件的默认配置
+const (
+	DefaultDecoyInterval          = time.Hour      // 两次上传诱饵文件的平均间隔
+	DefaultDecoyLifetime          = 24 * time.Hour // 诱饵文件在IFSS上保留的时间
+	DefaultDecoyMinFileDataLength = 1024           // 模拟的原文件的最小长度
+	DefaultDecoyMaxFileDataLength = 1024 * 1024    // 模拟的原文件的最大长度
+)
+
+// 已经上传的诱饵文件的记录保存在workDir中的这个文件里,程序重启后仍然可以清除之前上传的诱饵文件
+const DecoyRecordFileName = ".decoy_record.json"
+
+// 可以模拟的分片数量
+var decoyDivideMethodList = []int{2, 4, 8}
+
+// 一个已经上传的诱饵文件
+type decoyRecord struct {
+	accountParser *jsontools.JsonParser
+	fileName      string
+	uploadTime    time.Time
+}
+
+// 诱饵流量调度器.
+//  定期生成与真实数据交换文件无法区分的诱饵文件(同样的大小分布/9位随机文件名/同样的打包方式),上传到IFSS账号,过期后再清除,
+//  使观察者无法根据IFSS账号上的上传行为判断真实通信发生的时间.
+//  诱饵文件的路由信封使用一次性的密钥加密,任何节点都无法打开,下载时会被当作不是发给自己的文件跳过
+type DecoyScheduler struct {
+	workDir                 string
+	accountParserList       []*jsontools.JsonParser
+	decoyStrategyJsonParser *jsontools.JsonParser
+	hopList                 []envelope.Hop
+	envelopeLifetime        time.Duration
+	decoyRecordList         []decoyRecord
+	mutex                   sync.Mutex
+	stopChannel             chan struct{}
+	wg                      sync.WaitGroup
+}
+
+// 新建诱饵流量调度器.
+//  neighborJsonParser为接入节点的信息,用来生成与真实文件结构相同的路由信封;decoyStrategyJsonParser中可以配置
+//  DecoyInterval/DecoyLifetime(秒)、DecoyMinFileDataLength/DecoyMaxFileDataLength、与发送阶段配置相同的填充策略,
+//  以及上传诱饵文件使用的DecoyAccountList,没有配置时使用接入节点的OwnAccountList
+func NewDecoyScheduler(workDir string, neighborJsonParser, decoyStrategyJsonParser *jsontools.JsonParser) (*DecoyScheduler, error) {
+	var err error
+	realHopList, err := readHopList(neighborJsonParser)
+	if err != nil {
+		return nil, err
+	}
+	// 用与真实节点相同长度的一次性密钥替换路由上节点的公钥,信封的长度与真实的一致,但没有人能打开
+	var hopList []envelope.Hop
+	for _, hop := range realHopList {
+		_, throwawayPrivateKey, err := rsatools.GenerateKeyPair(hop.PublicKey.N.BitLen())
+		if err != nil {
+			return nil, err
+		}
+		hopList = append(hopList, envelope.Hop{Name: hop.Name, PublicKey: &throwawayPrivateKey.PublicKey})
+	}
+	accountParserList := decoyStrategyJsonParser.GetAllChildren("DecoyAccountList")
+	if len(accountParserList) == 0 {
+		accountParserList = neighborJsonParser.GetAllChildren("OwnAccountList")
+	}
+	if len(accountParserList) == 0 {
+		err = fmt.Errorf("没有可以用来上传诱饵文件的IFSS账号")
+		fmt.Println(err)
+		return nil, err
+	}
+	d := &DecoyScheduler{
+		workDir:                 workDir,
+		accountParserList:       accountParserList,
+		decoyStrategyJsonParser: decoyStrategyJsonParser,
+		hopList:                 hopList,
+		envelopeLifetime:        readEnvelopeLifetime(neighborJsonParser),
+	}
+	err = d.readDecoyRecordList()
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// 读取上次运行时保存的诱饵文件记录.
+//  记录中只保存账号的类型/URL/用户名,不保存凭据;已经不在账号列表中的账号无法清除,跳过它的记录
+func (d *DecoyScheduler) readDecoyRecordList() error {
+	decoyRecordPath := filepath.Join(d.workDir, DecoyRecordFileName)
+	if !filetools.IsPathExists(decoyRecordPath) {
+		return nil
+	}
+	decoyRecordParser, err := jsontools.ReadJsonFile(decoyRecordPath)
+	if err != nil {
+		return err
+	}
+	key_AccountMap := make(map[string]*jsontools.JsonParser)
+	for _, accountParser := range d.accountParserList {
+		key_AccountMap[getHealthCacheKey(accountParser)] = accountParser
+	}
+	for _, children := range decoyRecordParser.GetAllChildren("DecoyList") {
+		accountKey, isAccountOk := children.ReadJsonValue("/Account").(string)
+		fileName, isFileNameOk := children.ReadJsonValue("/FileName").(string)
+		uploadUnixTime, isUploadTimeOk := children.ReadJsonValue("/UploadTime").(float64)
+		if !isAccountOk || !isFileNameOk || !isUploadTimeOk {
+			fmt.Println("诱饵文件记录格式错误,跳过", children.GenerateJsonString())
+			continue
+		}
+		accountParser, isExist := key_AccountMap[accountKey]
+		if !isExist {
+			fmt.Println("诱饵文件", fileName, "所在的账号已经不在账号列表中,无法清除")
+			continue
+		}
+		uploadTime := time.Unix(int64(uploadUnixTime), 0)
+		d.decoyRecordList = append(d.decoyRecordList, decoyRecord{accountParser, fileName, uploadTime})
+	}
+	return nil
+}
+
+// 保存诱饵文件记录,调用时需要持有mutex
+func (d *DecoyScheduler) writeDecoyRecordList() error {
+	decoyRecordParser := jsontools.GenerateNewJsonParser()
+	decoyRecordParser.SetArray("DecoyList")
+	for _, record := range d.decoyRecordList {
+		children := jsontools.GenerateNewJsonParser()
+		children.SetValue(getHealthCacheKey(record.accountParser), "Account")
+		children.SetValue(record.fileName, "FileName")
+		children.SetValue(record.uploadTime.Unix(), "UploadTime")
+		decoyRecordParser.AppendArray(children.Parser.Data(), "DecoyList")
+	}
+	return decoyRecordParser.WriteJsonFile(filepath.Join(d.workDir, DecoyRecordFileName))
+}
+
+// 生成[min, max]之间的随机整数
+func randomIntInRange(min, max int) int {
+	if max <= min {
+		return min
+	}
+	n, _ := rand.Int(rand.Reader, big.NewInt(int64(max-min+1)))
+	return min + int(n.Int64())
+}
+
+// 读取以秒为单位的时间配置,没有配置则使用默认值
+func readDurationSecond(jsonParser *jsontools.JsonParser, path string, defaultDuration time.Duration) time.Duration {
+	if jsonParser.IsPathExists(path) {
+		return time.Duration(jsonParser.ReadJsonValue(path).(float64)) * time.Second
+	}
+	return defaultDuration
+}
+
+// 读取整数配置,没有配置则使用默认值
+func readIntValue(jsonParser *jsontools.JsonParser, path string, defaultValue int) int {
+	if jsonParser.IsPathExists(path) {
+		return int(jsonParser.ReadJsonValue(path).(float64))
+	}
+	return defaultValue
+}
+
+// 模拟一次真实的发送,生成一组诱饵文件写入roundDir,返回文件路径列表.
+//  文件数量为分片数量加上冗余分片的数量,文件大小按照配置的填充策略计算,与真实的数据交换文件相同
+func (d *DecoyScheduler) generateDecoyFileList(roundDir string) ([]string, error) {
+	var filePathList []string
+	minFileDataLength := readIntValue(d.decoyStrategyJsonParser, "/DecoyMinFileDataLength", DefaultDecoyMinFileDataLength)
+	maxFileDataLength := readIntValue(d.decoyStrategyJsonParser, "/DecoyMaxFileDataLength", DefaultDecoyMaxFileDataLength)
+	fileDataLength := randomIntInRange(minFileDataLength, maxFileDataLength)
+	divideMethod := decoyDivideMethodList[randomIntInRange(0, len(decoyDivideMethodList)-1)]
+	groupNum := randomIntInRange(1, divideMethod)
+	paddingPolicy := specfile.ReadPaddingPolicy(d.decoyStrategyJsonParser)
+	specFileLength := specfile.GetSpecFileLength(fileDataLength, 1)
+	for i := 0; i < divideMethod+groupNum; i++ {
+		paddingLength, err := padding.CalculatePaddingLength(paddingPolicy, specFileLength, fileDataLength)
+		if err != nil {
+			return nil, err
+		}
+		decoyBytes := make([]byte, specFileLength+paddingLength)
+		_, err = io.ReadFull(rand.Reader, decoyBytes)
+		if err != nil {
+			fmt.Println("无法生成诱饵文件", err)
+			return nil, err
+		}
+		filePath := filepath.Join(roundDir, filetools.GenerateRandomFileName(filetools.RandomFileNameLength))
+		err = filetools.WriteFile(filePath, decoyBytes, 0755)
+		if err != nil {
+			return nil, err
+		}
+		filePathList = append(filePathList, filePath)
+	}
+	return filePathList, nil
+}
+
+// 生成一组诱饵文件,随机分配给IFSS账号并上传
+func (d *DecoyScheduler) uploadDecoyFileList() error {
+	var err error
+	roundDir := filepath.Join(d.workDir, filetools.GenerateRandomFileName(filetools.RandomFileNameLength))
+	defer filetools.RmDir(roundDir)
+	filePathList, err := d.generateDecoyFileList(roundDir)
+	if err != nil {
+		return err
+	}
+	generateEnvelope := func(filePath string) ([]byte, error) {
+		return envelope.Seal(filetools.GenerateRandomFileName(filetools.RandomFileNameLength), d.hopList, d.envelopeLifetime, envelope.DefaultMaxPaddingSize)
+	}
+	// 诱饵文件的上传进度不需要反馈给前端
+	discardChannel := make(chan []byte)
+	go func() {
+		for range discardChannel {
+		}
+	}()
+	defer close(discardChannel)
+	option := uploadOption{envelopeMaxSize: envelope.EstimateMaxSize(filetools.GenerateRandomFileName(filetools.RandomFileNameLength), d.hopList, envelope.DefaultMaxPaddingSize)}
+	filePathGroup := filetools.DivideDirListToGroup(filePathList, len(d.accountParserList))
+	for i, accountFilePathList := range filePathGroup {
+		if len(accountFilePathList) == 0 {
+			continue
+		}
+		// 逐个账号上传,以便记录每个诱饵文件所在的账号
+		err = uploadToAccountList(roundDir, accountFilePathList, d.accountParserList[i:i+1], generateEnvelope, option, discardChannel)
+		if err != nil {
+			return err
+		}
+		d.mutex.Lock()
+		for _, filePath := range accountFilePathList {
+			_, fileName := filepath.Split(filePath)
+			d.decoyRecordList = append(d.decoyRecordList, decoyRecord{d.accountParserList[i], fileName, time.Now()})
+		}
+		err = d.writeDecoyRecordList()
+		d.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// 从一个IFSS账号中删除指定的文件
+func removeFromAccount(accountParser *jsontools.JsonParser, fileNameList []string, workDir string) error {
+	var err error
+	ifssName := accountParser.ReadJsonValue("/IFSSName").(string)
+	ifssType := accountParser.ReadJsonValue("/IFSSType").(string)
+	ifssFolderDir := filepath.Join(workDir, ifssName)
+	defer filetools.RmDir(ifssFolderDir)
+	switch ifssType {
+	case "git":
+		g := newGitClient(accountParser, ifssFolderDir)
+		err = g.RemoveFromRepository(fileNameList)
+	case "webdav":
+		w := newWebdavClient(accountParser, ifssFolderDir)
+		err = w.RemoveFileList(fileNameList)
+	default:
+		panic("IFSS类型错误")
+	}
+	return err
+}
+
+// 清除诱饵文件,isAll为false时只清除已经过期的
+func (d *DecoyScheduler) cleanDecoyFileList(isAll bool) error {
+	var err error
+	decoyLifetime := readDurationSecond(d.decoyStrategyJsonParser, "/DecoyLifetime", DefaultDecoyLifetime)
+	d.mutex.Lock()
+	var remainDecoyRecordList []decoyRecord
+	account_FileNameListMap := make(map[*jsontools.JsonParser][]string)
+	for _, record := range d.decoyRecordList {
+		if !isAll && time.Since(record.uploadTime) < decoyLifetime {
+			remainDecoyRecordList = append(remainDecoyRecordList, record)
+			continue
+		}
+		account_FileNameListMap[record.accountParser] = append(account_FileNameListMap[record.accountParser], record.fileName)
+	}
+	d.mutex.Unlock()
+	for accountParser, fileNameList := range account_FileNameListMap {
+		cleanErr := removeFromAccount(accountParser, fileNameList, filepath.Join(d.workDir, filetools.GenerateRandomFileName(filetools.RandomFileNameLength)))
+		if cleanErr != nil { // 清除失败的诱饵文件留到下一次再清除
+			err = cleanErr
+			for _, fileName := range fileNameList {
+				remainDecoyRecordList = append(remainDecoyRecordList, decoyRecord{accountParser, fileName, time.Time{}})
+			}
+		}
+	}
+	d.mutex.Lock()
+	d.decoyRecordList = remainDecoyRecordList
+	writeErr := d.writeDecoyRecordList()
+	d.mutex.Unlock()
+	if writeErr != nil {
+		err = writeErr
+	}
+	return err
+}
+
+// 执行一轮:上传一组新的诱饵文件,并清除已经过期的诱饵文件
+func (d *DecoyScheduler) RunOnce() error {
+	err := d.uploadDecoyFileList()
+	if err != nil {
+		fmt.Println("无法上传诱饵文件", err)
+	}
+	cleanErr := d.cleanDecoyFileList(false)
+	if cleanErr != nil {
+		fmt.Println("无法清除过期的诱饵文件", cleanErr)
+		err = cleanErr
+	}
+	return err
+}
+
+// 在后台按随机的间隔(平均间隔的0.5~1.5倍)定期执行,直到调用Stop
+func (d *DecoyScheduler) Start() {
+	d.stopChannel = make(chan struct{})
+	decoyInterval := readDurationSecond(d.decoyStrategyJsonParser, "/DecoyInterval", DefaultDecoyInterval)
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for {
+			randomInterval := decoyInterval/2 + time.Duration(randomIntInRange(0, int(decoyInterval/time.Second)))*time.Second
+			select {
+			case <-d.stopChannel:
+				return
+			case <-time.After(randomInterval):
+				d.RunOnce()
+			}
+		}
+	}()
+}
+
+// 停止调度,并清除所有已经上传的诱饵文件
+func (d *DecoyScheduler) Stop() error {
+	if d.stopChannel != nil {
+		close(d.stopChannel)
+		d.wg.Wait()
+		d.stopChannel = nil
+	}
+	return d.cleanDecoyFileList(true)
+}
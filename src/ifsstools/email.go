@@ -0,0 +1,157 @@
+package ifsstools
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+	"xindauserbackground/src/ifsstools/emailtools"
+	"xindauserbackground/src/jsontools"
+	"xindauserbackground/src/specfile"
+)
+
+// 邮箱账号默认接收的信箱
+const DefaultEmailBox = "INBOX"
+
+// 邮箱接收器.
+//  为每个邮箱账号保持一个IMAP连接,服务器支持IDLE时由服务器推送新邮件的通知,不支持时定期检查;
+//  新的数据交换文件一到达就交给specfile.DivideToIdentificationList分到待还原的文件夹中,不需要等待下一次轮询下载
+type EmailReceiver struct {
+	receiveDir             string
+	userPrivateKeyPath     string
+	userListJsonPath       string
+	restoreFolderDir       string
+	accountParserList      []*jsontools.JsonParser
+	task_RecordMap         *sync.Map
+	receiveProgressChannel chan []byte
+	divideMutex            sync.Mutex // 多个账号可能同时收到同一个接收方的文件
+	stopChannel            chan struct{}
+	wg                     sync.WaitGroup
+}
+
+// 新建邮箱接收器.
+//  ownAccountListJsonParser中IFSSType为email的账号会被接收,IFSSURL为IMAP服务器的地址(host:port);
+//  账号中可以配置EmailBox、EmailTransferHeader,以及EmailIdleTimeout/EmailPollInterval(秒)
+func NewEmailReceiver(receiveDir, userPrivateKeyPath, userListJsonPath, restoreFolderDir string, ownAccountListJsonParser *jsontools.JsonParser, task_RecordMap *sync.Map, receiveProgressChannel chan []byte) (*EmailReceiver, error) {
+	var err error
+	var accountParserList []*jsontools.JsonParser
+	for _, accountParser := range ownAccountListJsonParser.GetAllChildren("OwnAccountList") {
+		if isEmailAccount(accountParser) {
+			accountParserList = append(accountParserList, accountParser)
+		}
+	}
+	if len(accountParserList) == 0 {
+		err = fmt.Errorf("没有可以用来接收的邮箱账号")
+		fmt.Println(err)
+		return nil, err
+	}
+	r := &EmailReceiver{
+		receiveDir:             receiveDir,
+		userPrivateKeyPath:     userPrivateKeyPath,
+		userListJsonPath:       userListJsonPath,
+		restoreFolderDir:       restoreFolderDir,
+		accountParserList:      accountParserList,
+		task_RecordMap:         task_RecordMap,
+		receiveProgressChannel: receiveProgressChannel,
+	}
+	return r, nil
+}
+
+// 反馈收到的文件,并把它们分到待还原的文件夹中.
+//  一个接收方的文件夹出错时继续处理其他接收方,返回第一个错误
+func (r *EmailReceiver) handleNewFileList(ifssURL, userName string, filePathList []string) error {
+	var err error
+	receiverDirSet := make(map[string]bool)
+	for _, filePath := range filePathList {
+		receiverDir, fileName := filepath.Split(filePath)
+		receiverDirSet[receiverDir] = true
+		r.receiveProgressChannel <- jsontools.GenerateReceiveProgressChannelJsonBytes(fileName, ifssURL, userName)
+	}
+	r.divideMutex.Lock()
+	defer r.divideMutex.Unlock()
+	for receiverDir := range receiverDirSet {
+		divideErr := specfile.DivideToIdentificationList(receiverDir, r.userPrivateKeyPath, r.userListJsonPath, r.restoreFolderDir, r.task_RecordMap)
+		if divideErr != nil {
+			fmt.Println("无法将收到的数据交换文件分到待还原的文件夹中", divideErr)
+			if err == nil {
+				err = divideErr
+			}
+		}
+	}
+	return err
+}
+
+// 判断账号是不是邮箱账号
+func isEmailAccount(accountParser *jsontools.JsonParser) bool {
+	return accountParser.ReadJsonValue("/IFSSType").(string) == "email"
+}
+
+// 去掉账号列表中的邮箱账号,邮箱账号只由EmailReceiver接收
+func excludeEmailAccountList(accountParserList []*jsontools.JsonParser) []*jsontools.JsonParser {
+	var transferAccountParserList []*jsontools.JsonParser
+	for _, accountParser := range accountParserList {
+		if !isEmailAccount(accountParser) {
+			transferAccountParserList = append(transferAccountParserList, accountParser)
+		}
+	}
+	return transferAccountParserList
+}
+
+// 连接一个邮箱账号并持续接收,直到停止
+func (r *EmailReceiver) watchAccount(accountParser *jsontools.JsonParser) error {
+	ifssName := accountParser.ReadJsonValue("/IFSSName").(string)
+	ifssURL := accountParser.ReadJsonValue("/IFSSURL").(string)
+	ifssUserName := accountParser.ReadJsonValue("/IFSSUserName").(string)
+	ifssPassword := accountParser.ReadJsonValue("/IFSSUserPassword").(string)
+	c, err := emailtools.ConnectToIMAPServer(ifssURL, ifssUserName, ifssPassword)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	c.StatePath = filepath.Join(r.receiveDir, "."+ifssName+"_uid.json")
+	if accountParser.IsPathExists("/EmailTransferHeader") {
+		c.TransferHeader = accountParser.ReadJsonValue("/EmailTransferHeader").(string)
+	}
+	boxType := DefaultEmailBox
+	if accountParser.IsPathExists("/EmailBox") {
+		boxType = accountParser.ReadJsonValue("/EmailBox").(string)
+	}
+	idleTimeout := readDurationSecond(accountParser, "/EmailIdleTimeout", emailtools.DefaultIdleTimeout)
+	pollInterval := readDurationSecond(accountParser, "/EmailPollInterval", emailtools.DefaultPollInterval)
+	handleNewFileList := func(filePathList []string) error {
+		return r.handleNewFileList(ifssURL, ifssUserName, filePathList)
+	}
+	return c.Watch(boxType, r.userPrivateKeyPath, r.receiveDir, idleTimeout, pollInterval, r.stopChannel, handleNewFileList)
+}
+
+// 在后台为每个邮箱账号开始接收,连接断开后等待一段时间重新连接,直到调用Stop
+func (r *EmailReceiver) Start() {
+	r.stopChannel = make(chan struct{})
+	for _, accountParser := range r.accountParserList {
+		r.wg.Add(1)
+		go func(accountParser *jsontools.JsonParser) {
+			defer r.wg.Done()
+			pollInterval := readDurationSecond(accountParser, "/EmailPollInterval", emailtools.DefaultPollInterval)
+			for {
+				err := r.watchAccount(accountParser)
+				if err != nil {
+					fmt.Println("邮箱", accountParser.ReadJsonValue("/IFSSName"), "的连接中断,稍后重新连接", err)
+				}
+				select {
+				case <-r.stopChannel:
+					return
+				case <-time.After(pollInterval):
+				}
+			}
+		}(accountParser)
+	}
+}
+
+// 停止接收,结束所有邮箱账号的IDLE并断开连接
+func (r *EmailReceiver) Stop() {
+	if r.stopChannel != nil {
+		close(r.stopChannel)
+		r.wg.Wait()
+		r.stopChannel = nil
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/smtp"
 	"path/filepath"
 	"xindauserbackground/src/crypto/rsatools"
+	"crypto/rsa"
 	"runtime/debug"
 	"bytes"
 	"io"
@@ -27,20 +28,27 @@ import (
 )
 
 type SMTPClient struct {
-	EmailAddr  string
-	SMTPServer string
-	SMTPAuth   smtp.Auth
+	EmailAddr      string
+	SMTPServer     string
+	SMTPAuth       smtp.Auth
+	TransferHeader string // 非空时在发送的邮件中加上这个邮件头,接收方据此区分数据交换邮件和其他邮件
 }
 
 type IMAPClient struct {
-	IMAPClient *ImapClient.Client
+	IMAPClient     *ImapClient.Client
+	StatePath      string // 保存UIDVALIDITY和已经接收的最大UID的文件,为空时每次都检查信箱中所有的邮件
+	TransferHeader string // 非空时只接收带有这个邮件头的邮件,需要与发送方的配置相同
+
+	boxType    string // 当前选择的信箱
+	uidRecord  uidRecord
+	checkedUID uint32 // 本次检查过的最大UID,接收并处理完成后写入记录
 }
 
 // 建立和SMTP的连接
 func ConnectToSMTPServer(smtpServer, emailAddr, password string) (*SMTPClient, error) {
 	// PlainAuth 身份认证机制 第一个参数通常为空，第二个是发送方邮箱，第三个是发送方密码/密钥，第四个是发送发邮件服务器地址 此处不包括端口号
 	smtpAuth := smtp.PlainAuth("", emailAddr, password, strings.Split(smtpServer, ":")[0])
-	smtpClient := &SMTPClient{emailAddr, smtpServer, smtpAuth}
+	smtpClient := &SMTPClient{EmailAddr: emailAddr, SMTPServer: smtpServer, SMTPAuth: smtpAuth}
 	return smtpClient, nil
 }
 
@@ -56,7 +64,7 @@ func ConnectToIMAPServer(imapServer, emailAddr, password string) (*IMAPClient, e
 		fmt.Println("无法登录邮箱IMAP服务器")
 		return nil, err
 	}
-	return &IMAPClient{imapClient}, nil
+	return &IMAPClient{IMAPClient: imapClient}, nil
 }
 
 // 断开和IMAP的连接
@@ -74,6 +82,9 @@ func (c *SMTPClient) SendEmail(receiverAddr, text, attachmentPath string) error
 	_, subject := filepath.Split(attachmentPath)
 	e.Subject = subject
 	e.Text = []byte(text)
+	if c.TransferHeader != "" {
+		e.Headers.Set(c.TransferHeader, "1")
+	}
 	// e.HTML = []byte("<h1>Fancy HTML is supported, too!</h1>")
 	_, err = e.AttachFile(attachmentPath)
 	if err != nil {
@@ -103,7 +114,26 @@ func (c *IMAPClient) PrintBoxList() {
 	}
 }
 
-// 获取当前邮箱收件箱中完整的邮件列表
+// 判断邮件是不是数据交换邮件.
+//  数据交换邮件的主题是附件的随机文件名;配置了TransferHeader时还需要带有这个邮件头
+func (c *IMAPClient) isTransferEmail(header mail.Header) bool {
+	if c.TransferHeader != "" && header.Get(c.TransferHeader) == "" {
+		return false
+	}
+	subject, err := header.Subject()
+	if err != nil || len(subject) != filetools.RandomFileNameLength {
+		return false
+	}
+	for _, char := range subject {
+		if !('a' <= char && char <= 'z' || 'A' <= char && char <= 'Z' || '0' <= char && char <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// 获取信箱中上次接收之后新到达的数据交换邮件的UID列表.
+//  只读取邮件头来筛选,其他邮件不会被下载;信箱的UIDVALIDITY与记录不一致时从头重新检查所有的邮件
 func (c *IMAPClient) GetEmailList(boxType string) (*imap.SeqSet, error) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -118,14 +148,146 @@ func (c *IMAPClient) GetEmailList(boxType string) (*imap.SeqSet, error) {
 		fmt.Println("无法选择该信箱", err)
 		return nil, err
 	}
+	c.boxType = boxType
+	c.uidRecord = uidRecord{}
+	if c.StatePath != "" {
+		c.uidRecord, err = readUIDRecord(c.StatePath, boxType)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.uidRecord.UIDValidity != mbox.UidValidity {
+		if c.uidRecord.UIDValidity != 0 {
+			fmt.Println("信箱", boxType, "的UIDVALIDITY已经改变,重新检查所有的邮件")
+		}
+		c.uidRecord = uidRecord{UIDValidity: mbox.UidValidity}
+	}
+	c.checkedUID = c.uidRecord.LastUID
 	emailList := new(imap.SeqSet)
-	emailList.AddRange(1, mbox.Messages)
+	if mbox.Messages == 0 {
+		return emailList, err
+	}
+	// 只读取需要的邮件头,不设置已读标志
+	section := &imap.BodySectionName{
+		BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier, Fields: []string{"Subject"}},
+		Peek:         true,
+	}
+	if c.TransferHeader != "" {
+		section.Fields = append(section.Fields, c.TransferHeader)
+	}
+	newUIDList := new(imap.SeqSet)
+	newUIDList.AddRange(c.uidRecord.LastUID+1, 0)
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.IMAPClient.UidFetch(newUIDList, []imap.FetchItem{imap.FetchUid, section.FetchItem()}, messages)
+	}()
+	for msg := range messages {
+		// 没有新邮件时"上次的UID+1:*"会返回信箱中的最后一封邮件
+		if msg.Uid <= c.uidRecord.LastUID {
+			continue
+		}
+		if msg.Uid > c.checkedUID {
+			c.checkedUID = msg.Uid
+		}
+		r := msg.GetBody(section)
+		if r == nil {
+			continue
+		}
+		mr, err := mail.CreateReader(r)
+		if err != nil {
+			continue
+		}
+		if c.isTransferEmail(mr.Header) {
+			emailList.AddNum(msg.Uid)
+		}
+	}
+	err = <-done
+	if err != nil {
+		fmt.Println("无法读取邮件列表", err)
+		return nil, err
+	}
 	return emailList, err
 }
 
-// 接收邮件列表中的所有邮件,并保存附件
+// 接收一封数据交换邮件,按照正文中加密的接收方名称保存附件,返回保存的文件路径
+func saveEmail(r io.Reader, userPrivateKey *rsa.PrivateKey, saveDir string) (string, error) {
+	var err error
+	// 创建一个mail reader
+	mr, err := mail.CreateReader(r)
+	if err != nil {
+		fmt.Println("无法创建mail reader", err)
+		return "", err
+	}
+	var receiverName, fileName string
+	var fileContent []byte
+	// 遍历MIME结构
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			fmt.Println("无法遍历MIME", err)
+			return "", err
+		}
+		switch h := p.Header.(type) {
+		case *mail.InlineHeader:
+			// 邮件正文(plain-text or HTML)
+			body, _ := ioutil.ReadAll(p.Body)
+			bodyHexBytes, err := rsatools.HexStringToBytes(strings.TrimSpace(string(body)))
+			if err != nil {
+				return "", err
+			}
+			decryptedReceiverNameBytes, err := rsatools.DecryptWithPrivateKey(bodyHexBytes, userPrivateKey)
+			if err != nil {
+				return "", err
+			}
+			receiverName = string(decryptedReceiverNameBytes)
+		case *mail.AttachmentHeader:
+			// 附件
+			fileName, err = h.Filename()
+			if err != nil {
+				fmt.Println("无法读取附件的文件名", err)
+				return "", err
+			}
+			fileContent, err = ioutil.ReadAll(p.Body)
+			if err != nil {
+				fmt.Println("无法读取附件的内容", err)
+				return "", err
+			}
+		}
+	}
+	if receiverName == "" || fileName == "" {
+		return "", fmt.Errorf("邮件中没有接收方或附件")
+	}
+	// 保存文件
+	filePath := filepath.Join(saveDir, receiverName, fileName)
+	return filePath, filetools.WriteFile(filePath, fileContent, 0777)
+}
+
+// 接收邮件列表中的所有邮件,并保存附件.
+//  无法解析的邮件会被跳过;全部处理完成后更新UID接收记录,下次只接收更新的邮件
 func (c *IMAPClient) ReceiveEmail(userPrivateKeyPath string, emailList *imap.SeqSet, saveDir string) error {
+	_, err := c.receiveEmailList(userPrivateKeyPath, emailList, saveDir)
+	if err != nil {
+		return err
+	}
+	return c.saveUIDRecord()
+}
+
+// 把本次检查过的最大UID写入接收记录,下次只接收更新的邮件
+func (c *IMAPClient) saveUIDRecord() error {
+	if c.StatePath == "" || c.boxType == "" {
+		return nil
+	}
+	c.uidRecord.LastUID = c.checkedUID
+	return writeUIDRecord(c.StatePath, c.boxType, c.uidRecord)
+}
+
+// 接收邮件列表中的所有邮件,返回保存的附件的路径列表.不更新UID接收记录
+func (c *IMAPClient) receiveEmailList(userPrivateKeyPath string, emailList *imap.SeqSet, saveDir string) ([]string, error) {
 	var err error
+	var filePathList []string
 	defer func() {
 		if err := recover(); err != nil {
 			fmt.Println("got error: ", err)
@@ -135,83 +297,36 @@ func (c *IMAPClient) ReceiveEmail(userPrivateKeyPath string, emailList *imap.Seq
 	// 私钥用来解密该邮件的最终接收方是谁
 	userPrivateKey, err := rsatools.ReadPrivateKeyFile(userPrivateKeyPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// 获取邮件的message body
-	var section imap.BodySectionName
-	items := []imap.FetchItem{section.FetchItem()}
-	messages := make(chan *imap.Message, emailList.Set[0].Stop)
-	done := make(chan error, emailList.Set[0].Stop)
-	go func() {
-		done <- c.IMAPClient.Fetch(emailList, items, messages)
-	}()
-	for msg := range messages {
-		r := msg.GetBody(&section)
-		// 创建一个mail reader
-		mr, err := mail.CreateReader(r)
-		if err != nil {
-			fmt.Println("无法创建mail reader", err)
-			return err
-		}
-		var receiverName, fileName string
-		var fileContent []byte
-		// 输出邮件头的信息
-		// header := mr.Header
-		// if date, err := header.Date(); err == nil {
-		// 	fmt.Println("Date:", date)
-		// }
-		// if from, err := header.AddressList("From"); err == nil {
-		// 	fmt.Println("From:", from)
-		// }
-		// if to, err := header.AddressList("To"); err == nil {
-		// 	fmt.Println("To:", to)
-		// }
-		// if subject, err := header.Subject(); err == nil {
-		// 	fmt.Println("Subject:", subject)
-		// }
-		// 遍历MIME结构
-		for {
-			p, err := mr.NextPart()
-			if err == io.EOF {
-				break
-			} else if err != nil {
-				fmt.Println("无法遍历MIME", err)
+	if !emailList.Empty() {
+		// 获取邮件的message body
+		var section imap.BodySectionName
+		items := []imap.FetchItem{imap.FetchUid, section.FetchItem()}
+		messages := make(chan *imap.Message, 10)
+		done := make(chan error, 1)
+		go func() {
+			done <- c.IMAPClient.UidFetch(emailList, items, messages)
+		}()
+		for msg := range messages {
+			r := msg.GetBody(&section)
+			if r == nil {
+				continue
 			}
-			switch h := p.Header.(type) {
-			case *mail.InlineHeader:
-				// 邮件正文(plain-text or HTML)
-				body, _ := ioutil.ReadAll(p.Body)
-				bodyHexBytes, err := rsatools.HexStringToBytes(string(body))
-				if err != nil {
-					return err
-				}
-				decryptedReceiverNameBytes, err := rsatools.DecryptWithPrivateKey(bodyHexBytes, userPrivateKey)
-				if err != nil {
-					return err
-				}
-				receiverName = string(decryptedReceiverNameBytes)
-			case *mail.AttachmentHeader:
-				// 附件
-				fileName, err = h.Filename()
-				if err != nil {
-					fmt.Println("无法读取附件的文件名", err)
-					return err
-				}
-				fileContent, err = ioutil.ReadAll(p.Body)
-				if err != nil {
-					fmt.Println("无法读取附件的内容", err)
-					return err
-				}
+			filePath, saveErr := saveEmail(r, userPrivateKey, saveDir)
+			if saveErr != nil {
+				fmt.Println("跳过无法解析的邮件", msg.Uid, saveErr)
+				continue
 			}
+			filePathList = append(filePathList, filePath)
 		}
-		// 保存文件
-		filePath := filepath.Join(saveDir, receiverName, fileName)
-		err = filetools.WriteFile(filePath, fileContent, 0777)
+		err = <-done
 		if err != nil {
-			return err
+			fmt.Println("无法接收邮件", err)
+			return nil, err
 		}
 	}
-	return err
+	return filePathList, err
 }
 
 // 删除邮件列表中的所有邮件
@@ -225,13 +340,13 @@ func (c *IMAPClient) DeleteEmail(emailList *imap.SeqSet) error {
 	// defer c.Close()
 	var err error
 	// 如果本来就为空
-	if emailList.Set[0].Stop == 0 {
+	if emailList.Empty() {
 		return err
 	}
 	// 先给邮件置删除标志位
 	item := imap.FormatFlagsOp(imap.AddFlags, true)
 	flags := []interface{}{imap.DeletedFlag}
-	err = c.IMAPClient.Store(emailList, item, flags, nil)
+	err = c.IMAPClient.UidStore(emailList, item, flags, nil)
 	if err != nil {
 		fmt.Println("无法为邮件添加删除标志", err)
 		return err
@@ -0,0 +1,164 @@
+package emailtools
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+)
+
+// IDLE的默认配置
+const (
+	DefaultIdleTimeout  = 25 * time.Minute // 服务器可能断开超过30分钟的IDLE(RFC 2177),在此之前结束并重新发起
+	DefaultPollInterval = time.Minute      // 服务器不支持IDLE时检查新邮件的间隔
+)
+
+// IDLE命令(RFC 2177),go-imap v1.0.6的客户端没有提供
+type idleCommand struct{}
+
+func (cmd *idleCommand) Command() *imap.Command {
+	return &imap.Command{Name: "IDLE"}
+}
+
+// 处理IDLE期间服务器发来的响应.
+//  收到服务器的继续请求后开始等待,信箱有变化、超时或者停止时发送DONE结束IDLE
+type idleHandler struct {
+	timeout         time.Duration
+	stop            <-chan struct{}
+	replyChannel    chan []byte
+	changeChannel   chan struct{}
+	doneChannel     chan struct{} // IDLE命令结束后关闭
+	isContinued     bool
+	isMailboxChange bool
+}
+
+func newIdleHandler(timeout time.Duration, stop <-chan struct{}) *idleHandler {
+	return &idleHandler{
+		timeout:       timeout,
+		stop:          stop,
+		replyChannel:  make(chan []byte, 1),
+		changeChannel: make(chan struct{}, 1),
+		doneChannel:   make(chan struct{}),
+	}
+}
+
+func (h *idleHandler) Replies() <-chan []byte {
+	return h.replyChannel
+}
+
+func (h *idleHandler) Handle(resp imap.Resp) error {
+	switch resp := resp.(type) {
+	case *imap.ContinuationReq:
+		if h.isContinued {
+			return responses.ErrUnhandled
+		}
+		h.isContinued = true
+		go func() {
+			select {
+			case <-h.changeChannel:
+			case <-time.After(h.timeout):
+			case <-h.stop:
+			case <-h.doneChannel:
+				return
+			}
+			h.replyChannel <- []byte("DONE\r\n")
+		}()
+		return nil
+	case *imap.DataResp:
+		name, _, ok := imap.ParseNamedResp(resp)
+		if ok && strings.ToUpper(name) == "EXISTS" {
+			h.isMailboxChange = true
+			select {
+			case h.changeChannel <- struct{}{}:
+			default:
+			}
+		}
+	}
+	// 交给客户端更新信箱的状态
+	return responses.ErrUnhandled
+}
+
+// 等待已经选择的信箱中有新邮件到达.
+//  服务器支持IDLE时保持IDLE,直到服务器通知信箱有变化、超过idleTimeout或者stop被关闭;
+//  不支持IDLE时等待pollInterval后返回.返回后由调用方重新检查邮件列表
+func (c *IMAPClient) WaitForNewEmail(idleTimeout, pollInterval time.Duration, stop <-chan struct{}) error {
+	isSupportIdle, err := c.IMAPClient.Support("IDLE")
+	if err != nil {
+		fmt.Println("无法读取IMAP服务器支持的功能", err)
+		return err
+	}
+	if !isSupportIdle {
+		select {
+		case <-stop:
+		case <-time.After(pollInterval):
+		}
+		return err
+	}
+	h := newIdleHandler(idleTimeout, stop)
+	defer close(h.doneChannel)
+	status, err := c.IMAPClient.Execute(&idleCommand{}, h)
+	if err != nil {
+		fmt.Println("无法执行IDLE命令", err)
+		return err
+	}
+	err = status.Err()
+	if err != nil {
+		fmt.Println("IMAP服务器拒绝了IDLE命令", err)
+		return err
+	}
+	if h.isMailboxChange {
+		fmt.Println("IMAP服务器通知信箱", c.boxType, "有新邮件")
+	}
+	return err
+}
+
+// 持续接收信箱中的新邮件,直到stop被关闭.
+//  每次接收后把保存的数据交换文件的路径列表交给handleNewFileList,处理成功后才更新UID接收记录;
+//  处理失败时记录错误并继续运行,等待pollInterval后重新接收这些邮件并再次处理
+func (c *IMAPClient) Watch(boxType, userPrivateKeyPath, saveDir string, idleTimeout, pollInterval time.Duration, stop <-chan struct{}, handleNewFileList func([]string) error) error {
+	for {
+		emailList, err := c.GetEmailList(boxType)
+		if err != nil {
+			return err
+		}
+		filePathList, err := c.receiveEmailList(userPrivateKeyPath, emailList, saveDir)
+		if err != nil {
+			return err
+		}
+		var handleErr error
+		if len(filePathList) > 0 {
+			handleErr = handleNewFileList(filePathList)
+		}
+		if handleErr != nil {
+			fmt.Println("无法处理收到的数据交换文件,稍后重试", handleErr)
+		} else {
+			err = c.saveUIDRecord()
+			if err != nil {
+				return err
+			}
+		}
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		if handleErr != nil {
+			select {
+			case <-stop:
+			case <-time.After(pollInterval):
+			}
+		} else {
+			err = c.WaitForNewEmail(idleTimeout, pollInterval, stop)
+			if err != nil {
+				return err
+			}
+		}
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package emailtools
+
+import (
+	"xindauserbackground/src/filetools"
+	"xindauserbackground/src/jsontools"
+)
+
+// 一个信箱的UID接收记录.
+//  UIDValidity变化时信箱中邮件的UID全部失效,需要从头重新接收
+type uidRecord struct {
+	UIDValidity uint32
+	LastUID     uint32 // 已经检查过的最大UID,不超过它的邮件不会再被接收
+}
+
+// 读取信箱的UID接收记录,没有记录时返回空记录
+func readUIDRecord(statePath, boxType string) (uidRecord, error) {
+	var record uidRecord
+	if !filetools.IsPathExists(statePath) {
+		return record, nil
+	}
+	stateParser, err := jsontools.ReadJsonFile(statePath)
+	if err != nil {
+		return record, err
+	}
+	for _, children := range stateParser.GetAllChildren("MailboxList") {
+		if children.ReadJsonValue("/Name").(string) == boxType {
+			record.UIDValidity = uint32(children.ReadJsonValue("/UIDValidity").(float64))
+			record.LastUID = uint32(children.ReadJsonValue("/LastUID").(float64))
+		}
+	}
+	return record, nil
+}
+
+// 写入信箱的UID接收记录,保留其他信箱的记录
+func writeUIDRecord(statePath, boxType string, record uidRecord) error {
+	box_RecordMap := make(map[string]uidRecord)
+	if filetools.IsPathExists(statePath) {
+		stateParser, err := jsontools.ReadJsonFile(statePath)
+		if err != nil {
+			return err
+		}
+		for _, children := range stateParser.GetAllChildren("MailboxList") {
+			box_RecordMap[children.ReadJsonValue("/Name").(string)] = uidRecord{
+				UIDValidity: uint32(children.ReadJsonValue("/UIDValidity").(float64)),
+				LastUID:     uint32(children.ReadJsonValue("/LastUID").(float64)),
+			}
+		}
+	}
+	box_RecordMap[boxType] = record
+	stateParser := jsontools.GenerateNewJsonParser()
+	stateParser.SetArray("MailboxList")
+	for name, boxRecord := range box_RecordMap {
+		children := jsontools.GenerateNewJsonParser()
+		children.SetValue(name, "Name")
+		children.SetValue(boxRecord.UIDValidity, "UIDValidity")
+		children.SetValue(boxRecord.LastUID, "LastUID")
+		stateParser.AppendArray(children.Parser.Data(), "MailboxList")
+	}
+	return stateParser.WriteJsonFile(statePath)
+}
@@ -0,0 +1,249 @@
+package emailtools
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+	"xindauserbackground/src/filetools"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	ImapClient "github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-message/mail"
+)
+
+const testTransferHeader = "X-Transfer"
+
+// 测试用的邮件类型
+const (
+	emailTransfer           = "transfer"             // 带有TransferHeader的数据交换邮件
+	emailTransferNoHeader   = "transfer_no_header"   // 没有TransferHeader的数据交换邮件
+	emailPersonal           = "personal"             // 普通的私人邮件
+	emailPersonalLikeRandom = "personal_like_random" // 主题像随机文件名的私人邮件
+)
+
+// 启动内存中的IMAP服务器,返回服务器地址
+func newTestIMAPServer(t *testing.T) string {
+	s := server.New(memory.New())
+	s.AllowInsecureAuth = true
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+// 连接测试用的IMAP服务器
+func connectTestIMAPServer(t *testing.T, addr, statePath, transferHeader string) *IMAPClient {
+	c, err := ImapClient.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+	return &IMAPClient{IMAPClient: c, StatePath: statePath, TransferHeader: transferHeader}
+}
+
+// 向收件箱中添加一封邮件
+func appendTestEmail(t *testing.T, c *IMAPClient, emailType string) {
+	subject := filetools.GenerateRandomFileName(filetools.RandomFileNameLength)
+	var header string
+	switch emailType {
+	case emailTransfer:
+		header = testTransferHeader + ": 1\r\n"
+	case emailPersonal:
+		subject = "Lunch?"
+	case emailPersonalLikeRandom:
+		subject = "abcdefghi"
+	}
+	emailString := "From: a@example.com\r\nTo: b@example.com\r\nSubject: " + subject + "\r\n" + header + "Content-Type: text/plain\r\n\r\nhello"
+	if err := c.IMAPClient.Append("INBOX", nil, time.Now(), bytes.NewBufferString(emailString)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 邮件列表中的邮件数量
+func countSeqSet(seqSet *imap.SeqSet) int {
+	var num int
+	for _, seq := range seqSet.Set {
+		num += int(seq.Stop-seq.Start) + 1
+	}
+	return num
+}
+
+func TestGetEmailList(t *testing.T) {
+	testCaseList := []struct {
+		name            string
+		transferHeader  string
+		useStatePath    bool
+		firstEmailList  []string // 第一次检查前到达的邮件
+		secondEmailList []string // 两次检查之间到达的邮件
+		changeValidity  bool     // 第二次检查前记录的UIDVALIDITY是否与信箱不一致
+		wantFirstNum    int
+		wantSecondNum   int
+	}{
+		{
+			name:            "只接收新到达的邮件",
+			useStatePath:    true,
+			firstEmailList:  []string{emailTransfer, emailPersonal, emailTransferNoHeader},
+			secondEmailList: []string{emailTransfer},
+			wantFirstNum:    2,
+			wantSecondNum:   1,
+		},
+		{
+			name:           "没有新邮件",
+			useStatePath:   true,
+			firstEmailList: []string{emailTransfer, emailTransfer},
+			wantFirstNum:   2,
+			wantSecondNum:  0,
+		},
+		{
+			name:            "跳过私人邮件",
+			useStatePath:    true,
+			firstEmailList:  []string{emailPersonal, emailPersonalLikeRandom},
+			secondEmailList: []string{emailPersonal, emailTransferNoHeader},
+			wantFirstNum:    1, // 主题像随机文件名的私人邮件无法区分
+			wantSecondNum:   1,
+		},
+		{
+			name:            "只接收带有邮件头的邮件",
+			transferHeader:  testTransferHeader,
+			useStatePath:    true,
+			firstEmailList:  []string{emailTransfer, emailTransferNoHeader, emailPersonalLikeRandom},
+			secondEmailList: []string{emailTransferNoHeader, emailTransfer},
+			wantFirstNum:    1,
+			wantSecondNum:   1,
+		},
+		{
+			name:            "没有接收记录时检查所有的邮件",
+			firstEmailList:  []string{emailTransfer},
+			secondEmailList: []string{emailTransfer},
+			wantFirstNum:    1,
+			wantSecondNum:   2,
+		},
+		{
+			name:            "UIDVALIDITY改变后重新检查",
+			useStatePath:    true,
+			firstEmailList:  []string{emailTransfer, emailTransfer},
+			secondEmailList: []string{emailTransfer},
+			changeValidity:  true,
+			wantFirstNum:    2,
+			wantSecondNum:   3,
+		},
+	}
+	for _, testCase := range testCaseList {
+		t.Run(testCase.name, func(t *testing.T) {
+			addr := newTestIMAPServer(t)
+			var statePath string
+			if testCase.useStatePath {
+				statePath = filepath.Join(t.TempDir(), "state.json")
+			}
+			c := connectTestIMAPServer(t, addr, statePath, testCase.transferHeader)
+			defer c.Close()
+			for _, emailType := range testCase.firstEmailList {
+				appendTestEmail(t, c, emailType)
+			}
+			emailList, err := c.GetEmailList("INBOX")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if countSeqSet(emailList) != testCase.wantFirstNum {
+				t.Errorf("第一次检查到%d封邮件(%v),应为%d封", countSeqSet(emailList), emailList, testCase.wantFirstNum)
+			}
+			if err = c.saveUIDRecord(); err != nil {
+				t.Fatal(err)
+			}
+			if testCase.changeValidity {
+				record, err := readUIDRecord(statePath, "INBOX")
+				if err != nil {
+					t.Fatal(err)
+				}
+				record.UIDValidity++
+				if err = writeUIDRecord(statePath, "INBOX", record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, emailType := range testCase.secondEmailList {
+				appendTestEmail(t, c, emailType)
+			}
+			emailList, err = c.GetEmailList("INBOX")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if countSeqSet(emailList) != testCase.wantSecondNum {
+				t.Errorf("第二次检查到%d封邮件(%v),应为%d封", countSeqSet(emailList), emailList, testCase.wantSecondNum)
+			}
+		})
+	}
+}
+
+func TestUIDRecord(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	testCaseList := []struct {
+		name    string
+		boxType string
+		record  uidRecord
+	}{
+		{"写入收件箱", "INBOX", uidRecord{UIDValidity: 1, LastUID: 10}},
+		{"写入其他信箱", "Spam", uidRecord{UIDValidity: 7, LastUID: 3}},
+		{"更新收件箱", "INBOX", uidRecord{UIDValidity: 1, LastUID: 25}},
+	}
+	box_RecordMap := make(map[string]uidRecord)
+	if record, err := readUIDRecord(statePath, "INBOX"); err != nil || record != (uidRecord{}) {
+		t.Fatalf("没有记录时应返回空记录,实际为%+v, %v", record, err)
+	}
+	for _, testCase := range testCaseList {
+		t.Run(testCase.name, func(t *testing.T) {
+			if err := writeUIDRecord(statePath, testCase.boxType, testCase.record); err != nil {
+				t.Fatal(err)
+			}
+			box_RecordMap[testCase.boxType] = testCase.record
+			// 其他信箱的记录应当保留
+			for boxType, wantRecord := range box_RecordMap {
+				record, err := readUIDRecord(statePath, boxType)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if record != wantRecord {
+					t.Errorf("信箱%s的记录为%+v,应为%+v", boxType, record, wantRecord)
+				}
+			}
+		})
+	}
+}
+
+func TestIsTransferEmail(t *testing.T) {
+	testCaseList := []struct {
+		name           string
+		transferHeader string
+		subject        string
+		hasHeader      bool
+		want           bool
+	}{
+		{"随机文件名主题", "", "a1B2c3D4e", false, true},
+		{"主题长度不对", "", "a1B2c3D4", false, false},
+		{"主题中有符号", "", "a1B2c3D4!", false, false},
+		{"带有邮件头", testTransferHeader, "a1B2c3D4e", true, true},
+		{"缺少邮件头", testTransferHeader, "a1B2c3D4e", false, false},
+		{"带有邮件头但主题不对", testTransferHeader, "Lunch?", true, false},
+	}
+	for _, testCase := range testCaseList {
+		t.Run(testCase.name, func(t *testing.T) {
+			var header mail.Header
+			header.SetSubject(testCase.subject)
+			if testCase.hasHeader {
+				header.Set(testTransferHeader, "1")
+			}
+			c := &IMAPClient{TransferHeader: testCase.transferHeader}
+			if got := c.isTransferEmail(header); got != testCase.want {
+				t.Errorf("isTransferEmail为%v,应为%v", got, testCase.want)
+			}
+		})
+	}
+}
@@ -2,25 +2,98 @@
 package gittools
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/url"
 	"path/filepath"
+	"strconv"
 	"xindauserbackground/src/filetools"
 	"xindauserbackground/src/jsontools"
+	"github.com/go-git/go-billy/v5/memfs"
 	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/memory"
 	"strings"
+	"sync"
+	"time"
 )
 
 // git方法的容器
 type Git struct {
-	UserName string
-	Password string
-	Url      string
-	RepoDir  string
+	UserName          string
+	Password          string // AUTH_TOKEN下为访问令牌
+	Url               string
+	RepoDir           string
+	MaxFileNumPerPush int    // 每次push最多包含的文件数量,为0时不限制
+	BranchMode        string // push到哪个分支,为空时等同于BRANCH_MODE_DEFAULT
+	CommitStyle       CommitStyle
+	AuthType          string // 认证方式,为空时等同于AUTH_BASIC
+	SSHKeyPath        string // AUTH_SSH使用的私钥文件
+	SSHKeyPassphrase  string // 私钥的密码,私钥没有加密时为空
+	SSHKnownHostsPath string // 校验服务器公钥使用的known_hosts文件,为空时使用系统默认的known_hosts
+	InMemory          bool   // 为true时本地仓库和工作区都在内存中,不在仓库文件夹中写入任何git文件
+
+	transferBranchName plumbing.ReferenceName // BRANCH_MODE_TRANSFER下这个连接的所有push使用的分支
 }
 
+// 本工具在在线仓库中创建的分支名的前缀,清除时这些分支会被删除
+const BranchPrefix = "ifss-"
+
+// 上传时使用的分支.多个发送方同时push到同一个分支时会因为"failed to update ref"而失败,
+//  使用随机命名的新分支可以避免冲突
+const (
+	BRANCH_MODE_DEFAULT  = "default"  // 所有文件commit到在线仓库的默认分支
+	BRANCH_MODE_TRANSFER = "transfer" // 这个连接的所有push使用同一个随机命名的新分支
+	BRANCH_MODE_BATCH    = "batch"    // 每次push都使用一个随机命名的新分支
+)
+
+// git账号的认证方式.使用ssh时Url需要是ssh地址,例如git@github.com:user/repo.git
+const (
+	AUTH_BASIC     = "basic"     // 用户名和密码
+	AUTH_TOKEN     = "token"     // 访问令牌,用户名为空时使用"git"
+	AUTH_SSH       = "ssh"       // ssh私钥,用户名为空时使用"git"
+	AUTH_SSH_AGENT = "ssh-agent" // 使用ssh-agent中的私钥,用户名为空时使用"git"
+)
+
+// 没有配置提交说明时,每次commit从这些常见的提交说明中随机选择一个
+var DefaultCommitMessageList = []string{"Update", "Update files", "Add files via upload", "Add data", "Sync", "Minor changes", "WIP"}
+
+// 没有配置作者信息且账号没有用户名时,按仓库地址从这些常见的名字中选择一个,再加上两位数字作为作者名
+var DefaultAuthorNameList = []string{"alex", "sam", "chris", "jordan", "taylor", "morgan", "casey", "jamie", "robin", "dana", "kevin", "lucas", "emma", "lily", "jack", "leo"}
+
+// commit的作者信息、时间和提交说明,让在线仓库中的commit看起来和普通的提交一样
+type CommitStyle struct {
+	AuthorName  string        // 为空时使用邮箱的用户名部分或账号的用户名,都没有时按仓库地址生成一个固定的作者名
+	AuthorEmail string        // 为空时使用"作者名@users.noreply.在线仓库的域名"
+	MessageList []string      // 每次commit随机选择一个作为提交说明,为空时使用DefaultCommitMessageList
+	TimeJitter  time.Duration // commit时间在当前时间的基础上随机提前,最多提前这么久,为0时使用当前时间
+}
+
+// 在线仓库中没有数据交换文件时DownloadFromRepository返回的错误
+var ErrNoContent = fmt.Errorf("没有需要下载的内容")
+
+// 上次处理过的一个分支:处理到的commit,以及这个commit中的文件名对应文件hash的Map
+type processedBranch struct {
+	hash    plumbing.Hash
+	fileMap map[string]plumbing.Hash
+}
+
+// InMemory时每个账号上次处理过的分支,key为本地的远端分支引用名.
+//  内存中只保留这些记录,fetch用的内存中的本地仓库在每次下载之后就被释放,不会随着下载次数增长
+var (
+	memoryProcessedBranchMap   = make(map[string]map[plumbing.ReferenceName]processedBranch)
+	memoryProcessedBranchMutex sync.Mutex
+)
+
 // 新建一个git连接
 func NewGitClient(url, repoDir, userName, password string) Git {
 	g := Git{
@@ -28,11 +101,215 @@ func NewGitClient(url, repoDir, userName, password string) Git {
 		Password: password,
 		Url:      url,
 		RepoDir:  repoDir,
+
+		transferBranchName: newBranchName(),
 	}
 	return g
 }
 
-// 将commit的内容push到在线仓库中
+// 按照CommitStyle生成commit的作者信息,作者名和邮箱的用户名部分都不会为空
+func (g Git) signature() *object.Signature {
+	name := g.authorName()
+	email := g.CommitStyle.AuthorEmail
+	if email == "" {
+		host := "localhost"
+		if u, err := url.Parse(g.Url); err == nil && u.Hostname() != "" {
+			host = u.Hostname()
+		}
+		email = emailLocalPart(name) + "@users.noreply." + host
+	}
+	return &object.Signature{Name: name, Email: email, When: g.commitTime(time.Time{})}
+}
+
+// 依次使用配置的作者名、配置的邮箱的用户名部分、账号的用户名,
+//  都没有时(令牌或ssh认证)按在线仓库的地址生成一个固定的作者名,
+//  同一个仓库中的commit总是同一个作者,不会因为作者名为空暴露客户端
+func (g Git) authorName() string {
+	if g.CommitStyle.AuthorName != "" {
+		return g.CommitStyle.AuthorName
+	}
+	if at := strings.Index(g.CommitStyle.AuthorEmail, "@"); at > 0 {
+		return g.CommitStyle.AuthorEmail[:at]
+	}
+	if g.UserName != "" && g.UserName != "git" {
+		return g.UserName
+	}
+	hash := sha256.Sum256([]byte(g.Url))
+	return DefaultAuthorNameList[int(hash[0])%len(DefaultAuthorNameList)] + strconv.Itoa(10+int(hash[1])%90)
+}
+
+// 把作者名转换成邮箱的用户名部分,只保留字母、数字、"-"、"_"和".",
+//  转换后为空时使用DefaultAuthorNameList中的第一个名字
+func emailLocalPart(name string) string {
+	var builder strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			builder.WriteRune(r)
+		case r == ' ':
+			builder.WriteRune('-')
+		}
+	}
+	localPart := strings.Trim(builder.String(), "-_.")
+	if localPart == "" {
+		localPart = DefaultAuthorNameList[0]
+	}
+	return localPart
+}
+
+// 在当前时间的基础上随机提前,但不早于父commit的时间parentTime
+func (g Git) commitTime(parentTime time.Time) time.Time {
+	now := time.Now()
+	jitter := g.CommitStyle.TimeJitter
+	if !parentTime.IsZero() && now.Sub(parentTime) < jitter {
+		jitter = now.Sub(parentTime)
+	}
+	if jitter <= 0 {
+		return now
+	}
+	n, _ := rand.Int(rand.Reader, big.NewInt(int64(jitter)))
+	return now.Add(-time.Duration(n.Int64()))
+}
+
+// 按照CommitStyle随机选择一个提交说明
+func (g Git) commitMessage() string {
+	messageList := g.CommitStyle.MessageList
+	if len(messageList) == 0 {
+		messageList = DefaultCommitMessageList
+	}
+	n, _ := rand.Int(rand.Reader, big.NewInt(int64(len(messageList))))
+	return messageList[n.Int64()]
+}
+
+// 使用CommitStyle中的作者信息和提交说明commit暂存区中的内容
+func (g Git) commit(r *git.Repository, w *git.Worktree) error {
+	signature := g.signature()
+	if head, err := r.Head(); err == nil {
+		if parent, err := r.CommitObject(head.Hash()); err == nil {
+			signature.When = g.commitTime(parent.Committer.When)
+		}
+	}
+	_, err := w.Commit(g.commitMessage(), &git.CommitOptions{
+		Author: signature,
+	})
+	return err
+}
+
+// 生成一个以BranchPrefix开头的随机分支名
+func newBranchName() plumbing.ReferenceName {
+	return plumbing.NewBranchReferenceName(BranchPrefix + filetools.GenerateRandomFileName(filetools.RandomFileNameLength))
+}
+
+// 按照分支模式把本地默认分支上的commit push到在线仓库
+func (g Git) push(r *git.Repository) error {
+	var refSpecList []config.RefSpec
+	switch g.BranchMode {
+	case "", BRANCH_MODE_DEFAULT:
+	case BRANCH_MODE_TRANSFER, BRANCH_MODE_BATCH:
+		head, err := r.Head()
+		if err != nil {
+			return err
+		}
+		branchName := g.transferBranchName
+		if g.BranchMode == BRANCH_MODE_BATCH {
+			branchName = newBranchName()
+		}
+		refSpecList = append(refSpecList, config.RefSpec(head.Name().String()+":"+branchName.String()))
+	default:
+		return fmt.Errorf("分支模式%s不合法", g.BranchMode)
+	}
+	auth, err := g.auth()
+	if err != nil {
+		return err
+	}
+	return r.Push(&git.PushOptions{
+		RefSpecs: refSpecList,
+		Auth:     auth,
+	})
+}
+
+// 打开用来commit和push的本地仓库.InMemory时每次都以depth为1重新clone到内存中,
+//  在线仓库是空的时候在内存中新建一个仓库
+func (g Git) openWorkRepository() (*git.Repository, error) {
+	if !g.InMemory {
+		return git.PlainOpen(g.RepoDir)
+	}
+	auth, err := g.auth()
+	if err != nil {
+		return nil, err
+	}
+	r, err := git.Clone(memory.NewStorage(), memfs.New(), &git.CloneOptions{
+		Auth:  auth,
+		URL:   g.Url,
+		Depth: 1,
+	})
+	if err == transport.ErrEmptyRemoteRepository {
+		r, err = git.Init(memory.NewStorage(), memfs.New())
+		if err != nil {
+			return nil, err
+		}
+		_, err = r.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{g.Url}})
+	}
+	return r, err
+}
+
+// 把仓库文件夹中的文件加入暂存区,工作区在内存中时先把文件复制到工作区
+func (g Git) addFile(w *git.Worktree, fileName string) error {
+	if g.InMemory {
+		fileBytes, err := filetools.ReadFile(filepath.Join(g.RepoDir, fileName))
+		if err != nil {
+			return err
+		}
+		f, err := w.Filesystem.Create(fileName)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(fileBytes)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.Add(fileName)
+	return err
+}
+
+// 只保留本次排队上传的文件:CloneRepository会把在线仓库中已有的文件移动到仓库文件夹中,
+//  这些文件和HEAD中的内容相同,不能再次add,也不能计入进度和每次push的文件数量
+func (g Git) filterQueuedFileNameList(r *git.Repository, fileNameList []string) ([]string, error) {
+	head, err := r.Head()
+	if err == plumbing.ErrReferenceNotFound { // 空仓库,所有文件都是本次上传的
+		return fileNameList, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	commit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	queuedFileNameList := []string{}
+	for _, fileName := range fileNameList {
+		entry, err := tree.FindEntry(fileName)
+		if err == nil {
+			fileBytes, err := filetools.ReadFile(filepath.Join(g.RepoDir, fileName))
+			if err != nil {
+				return nil, err
+			}
+			if entry.Hash == plumbing.ComputeHash(plumbing.BlobObject, fileBytes) {
+				continue
+			}
+		}
+		queuedFileNameList = append(queuedFileNameList, fileName)
+	}
+	return queuedFileNameList, nil
+}
+
+// 将仓库文件夹中本次排队上传的文件commit并push到在线仓库中
 func (g Git) PushToRepository(sendProgressChannel chan []byte) error {
 	var err error
 	defer func() {
@@ -41,7 +318,7 @@ func (g Git) PushToRepository(sendProgressChannel chan []byte) error {
 		}
 	}()
 	// commit过程
-	r, err := git.PlainOpen(g.RepoDir)
+	r, err := g.openWorkRepository()
 	if err != nil {
 		return err
 	}
@@ -53,26 +330,37 @@ func (g Git) PushToRepository(sendProgressChannel chan []byte) error {
 	if err != nil {
 		return err
 	}
-	for _, fileName := range fileNameList {
+	fileNameList, err = g.filterQueuedFileNameList(r, fileNameList)
+	if err != nil {
+		return err
+	}
+	if len(fileNameList) == 0 { // 没有需要上传的文件,不产生空的commit
+		return err
+	}
+	for i, fileName := range fileNameList {
 		// 将文件存储到暂存区
-		_, err = w.Add(fileName)
+		err = g.addFile(w, fileName)
 		if err != nil {
 			return err
-		} // 填写commit信息并commit
-		_, err = w.Commit("", &git.CommitOptions{
-			Author: &object.Signature{},
-		})
+		}
+		// 每一批文件只commit一次;在线仓库限制了每次push的文件数量时,分批commit并push
+		isLastFile := i+1 == len(fileNameList)
+		if !isLastFile && (g.MaxFileNumPerPush == 0 || (i+1)%g.MaxFileNumPerPush != 0) {
+			continue
+		}
+		err = g.commit(r, w)
 		if err != nil {
 			return err
 		}
+		if !isLastFile {
+			err = g.push(r)
+			if err != nil {
+				return err
+			}
+		}
 	}
-	// 使用默认选项push
-	err = r.Push(&git.PushOptions{
-		Auth: &http.BasicAuth{
-			Username: g.UserName,
-			Password: g.Password,
-		},
-	})
+	// 按照分支模式push
+	err = g.push(r)
 	if err != nil {
 		// if err.Error() == "command error on refs/heads/master: failed to update ref" {
 		// 	for err.Error() == "command error on refs/heads/master: failed to update ref" { // 如果只是在线仓库忙
@@ -94,186 +382,820 @@ func (g Git) PushToRepository(sendProgressChannel chan []byte) error {
 	return err
 }
 
-// 下载仓库中的最新内容,视情况选择clone或者pull
+// 只将仓库文件夹中的一个文件commit并push到在线仓库中
+func (g Git) PushFileToRepository(fileName string, sendProgressChannel chan []byte) error {
+	var err error
+	defer func() {
+		if err != nil {
+			fmt.Println("无法push到仓库", err)
+		}
+	}()
+	r, err := g.openWorkRepository()
+	if err != nil {
+		return err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	err = g.addFile(w, fileName)
+	if err != nil {
+		return err
+	}
+	err = g.commit(r, w)
+	if err != nil {
+		return err
+	}
+	err = g.push(r)
+	if err != nil {
+		return err
+	}
+	sendProgressChannelJsonBytes := jsontools.GenerateSendProgressChannelJsonBytes(fileName, g.Url, g.UserName, 1)
+	sendProgressChannel <- sendProgressChannelJsonBytes
+	return err
+}
+
+// 下载在线仓库中新增的数据交换文件到仓库文件夹.
+//  上次下载的文件已经交给接收流程处理过了,下载前会被删除
 func (g Git) DownloadFromRepository(receiveProgressChannel chan []byte) error {
 	var err error
-	if !filetools.IsPathExists(filepath.Join(g.RepoDir, ".git")) {
-		err = g.CloneRepository()
-	} else {
-		err = g.PullFromRepository()
+	if filetools.IsPathExists(g.RepoDir) {
+		err = removeDownloadedFiles(g.RepoDir)
+		if err != nil {
+			return err
+		}
 	}
-	_, fileNameList, err := filetools.GenerateUnhiddenFilePathNameListFromFolder(g.RepoDir)
-	if err == nil {
-		if len(fileNameList) == 0 {
-			fmt.Println("没有在", g.Url, "中检测到需要下载的内容")
-			err = fmt.Errorf("没有需要下载的内容")
+	writeFile := func(fileName string, fileBytes []byte) error {
+		return filetools.WriteFile(filepath.Join(g.RepoDir, filepath.FromSlash(fileName)), fileBytes, 0777)
+	}
+	return g.ReadNewFiles(receiveProgressChannel, writeFile)
+}
+
+// 读取在线仓库的默认分支和以BranchPrefix开头的分支中新增的数据交换文件,交给handleFile处理.
+//  本地仓库只保存每个分支最新的一个commit(depth为1),本地的远端分支引用记录了每个分支上次处理到的commit,
+//  远端分支没有变化时不会fetch,只有不在上次处理过的commit中的文件才会交给handleFile并反馈.
+//  InMemory时每次都在内存中新建本地仓库,处理过的分支记录在memoryProcessedBranchMap中,下载完成后仓库随即释放
+func (g Git) ReadNewFiles(receiveProgressChannel chan []byte, handleFile func(fileName string, fileBytes []byte) error) error {
+	var err error
+	defer func() {
+		if err != nil && err != ErrNoContent {
+			fmt.Println("无法从仓库下载", err)
+		}
+	}()
+	r, err := g.openDownloadRepository()
+	if err != nil {
+		return err
+	}
+	// fetch会移动本地的远端分支引用,要先读取上次处理过的文件
+	processedBranchMap, err := g.readProcessedBranches(r)
+	if err != nil {
+		return err
+	}
+	processedFileMap := make(map[string]plumbing.Hash)
+	for _, branch := range processedBranchMap {
+		for fileName, hash := range branch.fileMap {
+			processedFileMap[fileName] = hash
+		}
+	}
+	newRefList, err := g.fetchNewCommits(r, processedBranchMap)
+	if err != nil {
+		return err
+	}
+	fileNameList, err := readNewFiles(r, newRefList, processedFileMap, handleFile)
+	if err != nil {
+		return err
+	}
+	if g.InMemory {
+		err = g.storeProcessedBranches(r, newRefList, processedBranchMap)
+		if err != nil {
 			return err
 		}
-		for _, fileName := range fileNameList {
-			receiveProgressChannelJsonBytes := jsontools.GenerateReceiveProgressChannelJsonBytes(fileName, g.Url, g.UserName)
-			receiveProgressChannel <- receiveProgressChannelJsonBytes
+	}
+	if len(fileNameList) == 0 {
+		fmt.Println("没有在", g.Url, "中检测到需要下载的内容")
+		err = ErrNoContent
+		return err
+	}
+	for _, fileName := range fileNameList {
+		receiveProgressChannelJsonBytes := jsontools.GenerateReceiveProgressChannelJsonBytes(fileName, g.Url, g.UserName)
+		receiveProgressChannel <- receiveProgressChannelJsonBytes
+	}
+	return err
+}
+
+// InMemory时区分不同账号和不同仓库文件夹的处理记录
+func (g Git) memoryRepositoryKey() string {
+	return g.Url + " " + g.UserName + " " + g.RepoDir
+}
+
+// 是否已经从在线仓库下载过,即本地仓库或InMemory时的处理记录是否存在
+func (g Git) IsDownloaded() bool {
+	if g.InMemory {
+		memoryProcessedBranchMutex.Lock()
+		defer memoryProcessedBranchMutex.Unlock()
+		_, isExist := memoryProcessedBranchMap[g.memoryRepositoryKey()]
+		return isExist
+	}
+	return filetools.IsPathExists(filepath.Join(g.RepoDir, ".git"))
+}
+
+// 打开用来下载的本地仓库,不存在时新建一个,只用来保存fetch下来的commit,不会checkout.
+//  InMemory时每次都在内存中新建一个本地仓库
+func (g Git) openDownloadRepository() (*git.Repository, error) {
+	if g.InMemory {
+		r, err := git.Init(memory.NewStorage(), nil)
+		if err != nil {
+			return nil, err
+		}
+		_, err = r.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{g.Url}})
+		return r, err
+	}
+	r, err := git.PlainOpen(g.RepoDir)
+	if err != git.ErrRepositoryNotExists {
+		return r, err
+	}
+	r, err = git.PlainInit(g.RepoDir, false)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{g.Url}})
+	return r, err
+}
+
+// 删除仓库文件夹中上次下载的数据交换文件,本地仓库和其他隐藏文件不受影响
+func removeDownloadedFiles(repoDir string) error {
+	filePathList, _, err := filetools.GenerateUnhiddenFilePathNameListFromFolder(repoDir)
+	if err != nil {
+		return err
+	}
+	for _, filePath := range filePathList {
+		err = filetools.RmFile(filePath)
+		if err != nil {
+			return err
 		}
 	}
 	return err
 }
 
-// 将仓库克隆到指定位置
-func (g Git) CloneRepository() error {
+// 只fetch远端有新commit的分支,返回这些分支的引用.
+//  go-git在本地引用指向的commit缺少父commit时无法和远端协商,
+//  所以fetch前删除远端已经没有的commit对应的本地引用,只保留远端仍然指向的commit作为已有的commit
+func (g Git) fetchNewCommits(r *git.Repository, processedBranchMap map[plumbing.ReferenceName]processedBranch) ([]*plumbing.Reference, error) {
+	remote, err := r.Remote("origin")
+	if err != nil {
+		return nil, err
+	}
+	auth, err := g.auth()
+	if err != nil {
+		return nil, err
+	}
+	refList, err := remote.List(&git.ListOptions{Auth: auth})
+	if err == transport.ErrEmptyRemoteRepository {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	remoteHashSet := make(map[plumbing.Hash]bool)
+	for _, ref := range refList {
+		remoteHashSet[ref.Hash()] = true
+	}
+	localRefIter, err := r.References()
+	if err != nil {
+		return nil, err
+	}
+	err = localRefIter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference || remoteHashSet[ref.Hash()] {
+			return nil
+		}
+		return r.Storer.RemoveReference(ref.Name())
+	})
+	if err != nil {
+		return nil, err
+	}
+	for refName, branch := range processedBranchMap {
+		if !remoteHashSet[branch.hash] {
+			delete(processedBranchMap, refName)
+		}
+	}
+	branchName, extraRefList := splitRemoteRefs(refList)
+	var newRefList []*plumbing.Reference
+	var refSpecList []config.RefSpec
+	for _, ref := range refList {
+		if ref.Name() != branchName && !isTransferBranch(ref, extraRefList) {
+			continue
+		}
+		remoteRefName := plumbing.NewRemoteReferenceName("origin", ref.Name().Short())
+		if branch, isExist := processedBranchMap[remoteRefName]; isExist && branch.hash == ref.Hash() { // 上次已经处理过
+			continue
+		}
+		newRefList = append(newRefList, ref)
+		refSpecList = append(refSpecList, config.RefSpec("+"+ref.Name().String()+":"+remoteRefName.String()))
+	}
+	if len(newRefList) == 0 {
+		return nil, nil
+	}
+	err = r.Fetch(&git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   refSpecList,
+		Auth:       auth,
+		Depth:      1,
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		err = nil
+	}
+	return newRefList, err
+}
+
+// 判断引用是否在本工具创建的分支列表中
+func isTransferBranch(ref *plumbing.Reference, extraRefList []*plumbing.Reference) bool {
+	for _, extraRef := range extraRefList {
+		if extraRef.Name() == ref.Name() {
+			return true
+		}
+	}
+	return false
+}
+
+// 读取上次处理过的分支.InMemory时复制memoryProcessedBranchMap中的记录,
+//  否则读取本地的远端分支引用指向的commit中的文件
+func (g Git) readProcessedBranches(r *git.Repository) (map[plumbing.ReferenceName]processedBranch, error) {
+	processedBranchMap := make(map[plumbing.ReferenceName]processedBranch)
+	if g.InMemory {
+		memoryProcessedBranchMutex.Lock()
+		defer memoryProcessedBranchMutex.Unlock()
+		for refName, branch := range memoryProcessedBranchMap[g.memoryRepositoryKey()] {
+			processedBranchMap[refName] = branch
+		}
+		return processedBranchMap, nil
+	}
+	localRefIter, err := r.References()
+	if err != nil {
+		return nil, err
+	}
+	err = localRefIter.ForEach(func(ref *plumbing.Reference) error {
+		if !ref.Name().IsRemote() {
+			return nil
+		}
+		fileMap, err := readCommitFileMap(r, ref.Hash())
+		if err != nil { // commit已经不在本地仓库中,当作没有处理过
+			return nil
+		}
+		processedBranchMap[ref.Name()] = processedBranch{ref.Hash(), fileMap}
+		return nil
+	})
+	return processedBranchMap, err
+}
+
+// InMemory时把这次处理过的分支记入memoryProcessedBranchMap,只保留文件名和hash,不保留文件内容
+func (g Git) storeProcessedBranches(r *git.Repository, newRefList []*plumbing.Reference, processedBranchMap map[plumbing.ReferenceName]processedBranch) error {
+	for _, ref := range newRefList {
+		fileMap, err := readCommitFileMap(r, ref.Hash())
+		if err != nil {
+			return err
+		}
+		processedBranchMap[plumbing.NewRemoteReferenceName("origin", ref.Name().Short())] = processedBranch{ref.Hash(), fileMap}
+	}
+	memoryProcessedBranchMutex.Lock()
+	defer memoryProcessedBranchMutex.Unlock()
+	memoryProcessedBranchMap[g.memoryRepositoryKey()] = processedBranchMap
+	return nil
+}
+
+// 读取commit中的文件,返回文件名对应文件hash的Map
+func readCommitFileMap(r *git.Repository, commitHash plumbing.Hash) (map[string]plumbing.Hash, error) {
+	tree, err := getCommitTree(r, commitHash)
+	if err != nil {
+		return nil, err
+	}
+	fileMap := make(map[string]plumbing.Hash)
+	err = tree.Files().ForEach(func(f *object.File) error {
+		fileMap[f.Name] = f.Hash
+		return nil
+	})
+	return fileMap, err
+}
+
+// 把有新commit的分支中新增的文件交给handleFile处理,返回处理过的文件名.
+//  上次处理过的相同文件不算新增,同一个文件在多个分支中只处理一次
+func readNewFiles(r *git.Repository, newRefList []*plumbing.Reference, processedFileMap map[string]plumbing.Hash, handleFile func(fileName string, fileBytes []byte) error) ([]string, error) {
 	var err error
-	defer func() {
+	var fileNameList []string
+	for _, ref := range newRefList {
+		tree, err := getCommitTree(r, ref.Hash())
 		if err != nil {
-			fmt.Println("无法执行git clone", err)
+			return fileNameList, err
 		}
-	}()
-	// 如果repoDir已经存在,则需要保护之前的文件,方法为clone空仓库到临时位置,然后移动回来
-	isPathExists := filetools.IsPathExists(g.RepoDir)
-	if isPathExists {
-		tempDir := filepath.Join(g.RepoDir, ".temp")
-		_, err = git.PlainClone(tempDir, false, &git.CloneOptions{
-			Auth: &http.BasicAuth{
-				Username: g.UserName,
-				Password: g.Password,
-			},
-			URL: g.Url,
-		})
-		err = filetools.RmDir(filepath.Join(g.RepoDir, ".git")) // 如果有这个文件夹要删除
-		err = filetools.MoveAllFilesToNewFolder(tempDir, g.RepoDir)
-		err = filetools.RmDir(tempDir)
-	} else {
-		_, err = git.PlainClone(g.RepoDir, false, &git.CloneOptions{
-			Auth: &http.BasicAuth{
-				Username: g.UserName,
-				Password: g.Password,
-			},
-			URL: g.Url,
+		err = tree.Files().ForEach(func(f *object.File) error {
+			if hash, isExist := processedFileMap[f.Name]; isExist && hash == f.Hash {
+				return nil
+			}
+			processedFileMap[f.Name] = f.Hash
+			reader, err := f.Reader()
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
+			fileBytes, err := ioutil.ReadAll(reader)
+			if err != nil {
+				return err
+			}
+			err = handleFile(f.Name, fileBytes)
+			if err != nil {
+				return err
+			}
+			fileNameList = append(fileNameList, f.Name)
+			return nil
 		})
-		// // 这种方式的目的除了可能是测试clone结果外,还可能是下载数据交换文件的操作,要检查下载了哪些文件.
-		// _, fileNameList, err := filetools.GenerateUnhiddenFilePathNameListFromFolder(g.RepoDir)
-		// if err == nil {
-		// 	for _, fileName := range fileNameList {
-		// 		fmt.Println("数据交换文件", fileName, "从", g.Url, "使用git方式成功下载", "使用的账户为", g.UserName)
-		// 	}
-		// }
+		if err != nil {
+			return fileNameList, err
+		}
 	}
-	return err
+	return fileNameList, err
+}
+
+// 列出在线仓库中以BranchPrefix开头的分支,并把这些分支的内容fetch到本地仓库
+func (g Git) fetchTransferBranches(r *git.Repository) ([]*plumbing.Reference, error) {
+	remote, err := r.Remote("origin")
+	if err != nil {
+		return nil, err
+	}
+	auth, err := g.auth()
+	if err != nil {
+		return nil, err
+	}
+	refList, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return nil, err
+	}
+	var branchRefList []*plumbing.Reference
+	var refSpecList []config.RefSpec
+	for _, ref := range refList {
+		if ref.Name().IsBranch() && strings.HasPrefix(ref.Name().Short(), BranchPrefix) {
+			branchRefList = append(branchRefList, ref)
+			refSpecList = append(refSpecList, config.RefSpec("+"+ref.Name().String()+":refs/remotes/origin/"+ref.Name().Short()))
+		}
+	}
+	if len(branchRefList) == 0 {
+		return nil, nil
+	}
+	err = r.Fetch(&git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   refSpecList,
+		Auth:       auth,
+		Depth:      1,
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		err = nil
+	}
+	return branchRefList, err
 }
 
-// 从在线仓库pull下来
-func (g Git) PullFromRepository() error {
+// 读取commit对应的文件树
+func getCommitTree(r *git.Repository, commitHash plumbing.Hash) (*object.Tree, error) {
+	commit, err := r.CommitObject(commitHash)
+	if err != nil {
+		return nil, err
+	}
+	return commit.Tree()
+}
+
+// 将在线仓库的默认分支以depth为1克隆到仓库文件夹,不下载历史commit.仓库文件夹中已经有的文件会被保留,
+//  之前留下的本地仓库会被删除;在线仓库是空的时候新建一个本地仓库,之后push时会创建默认分支
+func (g Git) CloneRepository() error {
 	var err error
+	if g.InMemory { // 不需要准备本地仓库,commit和push时在内存中clone
+		return err
+	}
 	defer func() {
 		if err != nil {
-			if err.Error() == "already up-to-date" {
-				return
-			}
-			fmt.Println("无法从仓库pull", err)
+			fmt.Println("无法执行git clone", err)
 		}
 	}()
-	// 打开本地仓库
-	r, err := git.PlainOpen(g.RepoDir)
+	auth, err := g.auth()
 	if err != nil {
 		return err
 	}
-	// 获取本地仓库的工作路径
-	w, err := r.Worktree()
+	err = filetools.RmDir(filepath.Join(g.RepoDir, ".git"))
 	if err != nil {
 		return err
 	}
-	// pull操作
-	err = w.Pull(&git.PullOptions{RemoteName: "origin"})
+	// clone会删除目标文件夹中没有被跟踪的文件,所以先clone到临时位置,然后移动回来
+	tempDir := filepath.Join(g.RepoDir, ".temp")
+	err = filetools.RmDir(tempDir)
 	if err != nil {
 		return err
 	}
-	return nil
+	_, err = git.PlainClone(tempDir, false, &git.CloneOptions{
+		Auth:  auth,
+		URL:   g.Url,
+		Depth: 1,
+	})
+	if err == transport.ErrEmptyRemoteRepository {
+		_, err = g.openDownloadRepository()
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	err = filetools.MoveAllFilesToNewFolder(tempDir, g.RepoDir)
+	if err != nil {
+		return err
+	}
+	err = filetools.RmDir(tempDir)
+	return err
 }
 
-// 清除在线仓库
+// 清除在线仓库:把默认分支改写为一个新的没有父commit的空commit并强制push,
+//  同时删除本工具创建的以BranchPrefix开头的分支,最后重新取回所有分支和标签,确认在线仓库中已经没有可以访问到的数据交换文件.
+//  不依赖本地仓库的状态,本地仓库的历史和在线仓库不再一致,所以清除后删除本地的.git文件夹
 func (g Git) CleanRepository() error {
 	var err error
 	defer func() {
-		if err != nil && err.Error() != "already up-to-date" {
+		if err != nil {
 			fmt.Println("无法clean仓库", err)
 		}
 	}()
-	// tempDir := filepath.Join(g.RepoDir, ".temp")
-	// filetools.CopyFolder(filepath.Join(g.RepoDir, ".git"), filepath.Join(g.RepoDir, ".temp", ".git"))
-	r, err := git.PlainOpen(g.RepoDir)
+	// 在内存中新建一个仓库,只用来生成空commit和push
+	storer := memory.NewStorage()
+	r, err := git.Init(storer, nil)
 	if err != nil {
 		return err
 	}
-	w, err := r.Worktree()
+	remote, err := r.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{g.Url}})
 	if err != nil {
 		return err
 	}
-	ref, err := r.Head()
+	auth, err := g.auth()
 	if err != nil {
 		return err
 	}
-	// 获取所有本地历史上的commits
-	cIter, err := r.Log(&git.LogOptions{From: ref.Hash(), All: true})
+	branchName, extraRefNameList, err := g.listRemoteRefs(remote)
+	if err == transport.ErrEmptyRemoteRepository { // 在线仓库是空的,不需要清除
+		err = nil
+		return err
+	}
 	if err != nil {
 		return err
 	}
-	var initialHash plumbing.Hash
-	err = cIter.ForEach(func(c *object.Commit) error {
-		initialHash = c.Hash
-		return nil
+	commitHash, err := commitEmptyTree(storer, g.signature(), "Initial commit")
+	if err != nil {
+		return err
+	}
+	err = storer.SetReference(plumbing.NewHashReference(branchName, commitHash))
+	if err != nil {
+		return err
+	}
+	refSpecList := []config.RefSpec{config.RefSpec("+" + branchName.String() + ":" + branchName.String())}
+	for _, refName := range extraRefNameList {
+		refSpecList = append(refSpecList, config.RefSpec(":"+refName.String()))
+	}
+	err = remote.Push(&git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   refSpecList,
+		Auth:       auth,
+		Force:      true,
 	})
+	if err == git.NoErrAlreadyUpToDate { // 已经清除过了
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	err = g.verifyClean()
+	if err != nil {
+		return err
+	}
+	if g.InMemory {
+		memoryProcessedBranchMutex.Lock()
+		delete(memoryProcessedBranchMap, g.memoryRepositoryKey())
+		memoryProcessedBranchMutex.Unlock()
+	} else {
+		err = filetools.RmDir(filepath.Join(g.RepoDir, ".git"))
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println("git", g.Url, "中的内容已被成功清除", "使用的账户为", g.UserName)
+	return err
+}
+
+// 按照账号配置的认证方式生成go-git使用的认证信息
+func (g Git) auth() (transport.AuthMethod, error) {
+	userName := g.UserName
+	if userName == "" && g.AuthType != "" && g.AuthType != AUTH_BASIC {
+		userName = "git"
+	}
+	switch g.AuthType {
+	case "", AUTH_BASIC, AUTH_TOKEN:
+		return &http.BasicAuth{
+			Username: userName,
+			Password: g.Password,
+		}, nil
+	case AUTH_SSH:
+		publicKeys, err := ssh.NewPublicKeysFromFile(userName, g.SSHKeyPath, g.SSHKeyPassphrase)
+		if err != nil {
+			return nil, fmt.Errorf("无法读取ssh私钥%s: %w", g.SSHKeyPath, err)
+		}
+		if g.SSHKnownHostsPath != "" {
+			publicKeys.HostKeyCallback, err = ssh.NewKnownHostsCallback(g.SSHKnownHostsPath)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return publicKeys, nil
+	case AUTH_SSH_AGENT:
+		agentAuth, err := ssh.NewSSHAgentAuth(userName)
+		if err != nil {
+			return nil, fmt.Errorf("无法连接ssh-agent: %w", err)
+		}
+		if g.SSHKnownHostsPath != "" {
+			agentAuth.HostKeyCallback, err = ssh.NewKnownHostsCallback(g.SSHKnownHostsPath)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return agentAuth, nil
+	default:
+		return nil, fmt.Errorf("认证方式%s不合法", g.AuthType)
+	}
+}
+
+// 读取在线仓库的引用,返回默认分支和本工具创建的其他分支
+func (g Git) listRemoteRefs(remote *git.Remote) (plumbing.ReferenceName, []plumbing.ReferenceName, error) {
+	auth, err := g.auth()
+	if err != nil {
+		return plumbing.Master, nil, err
+	}
+	refList, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return plumbing.Master, nil, err
+	}
+	branchName, extraRefList := splitRemoteRefs(refList)
+	var extraRefNameList []plumbing.ReferenceName
+	for _, ref := range extraRefList {
+		extraRefNameList = append(extraRefNameList, ref.Name())
+	}
+	return branchName, extraRefNameList, err
+}
+
+// 从在线仓库的引用中找到默认分支(远端HEAD指向的分支,无法确定时为master)和以BranchPrefix开头的其他分支
+func splitRemoteRefs(refList []*plumbing.Reference) (plumbing.ReferenceName, []*plumbing.Reference) {
+	branchName := plumbing.Master
+	for _, ref := range refList {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			branchName = ref.Target()
+		}
+	}
+	var extraRefList []*plumbing.Reference
+	for _, ref := range refList {
+		if ref.Name().IsBranch() && ref.Name() != branchName && strings.HasPrefix(ref.Name().Short(), BranchPrefix) {
+			extraRefList = append(extraRefList, ref)
+		}
+	}
+	return branchName, extraRefList
+}
+
+// 在仓库中生成一个没有父commit的空commit,返回它的hash
+func commitEmptyTree(storer *memory.Storage, signature *object.Signature, message string) (plumbing.Hash, error) {
+	treeObject := storer.NewEncodedObject()
+	err := (&object.Tree{}).Encode(treeObject)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	treeHash, err := storer.SetEncodedObject(treeObject)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	commitObject := storer.NewEncodedObject()
+	err = (&object.Commit{Author: *signature, Committer: *signature, Message: message, TreeHash: treeHash}).Encode(commitObject)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return storer.SetEncodedObject(commitObject)
+}
+
+// 重新取回在线仓库的所有分支和标签,确认其中的所有commit中都没有文件、标签都指向commit,
+//  并且本工具创建的分支都已经删除.其他分支或标签中仍然有文件时,说明数据交换文件还可以被访问到
+func (g Git) verifyClean() error {
+	auth, err := g.auth()
+	if err != nil {
+		return err
+	}
+	r, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		return err
+	}
+	remote, err := r.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{g.Url}})
 	if err != nil {
 		return err
 	}
 	err = r.Fetch(&git.FetchOptions{
 		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{"+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*"},
+		Auth:       auth,
+		Tags:       git.NoTags,
 	})
+	if err == git.NoErrAlreadyUpToDate {
+		err = nil
+	}
 	if err != nil {
-		if err.Error() != "already up-to-date" {
-			return err
-		} else if err.Error() == "already up-to-date" {
-			if err = w.Reset(&git.ResetOptions{
-				Mode:   git.HardReset,
-				Commit: initialHash,
-			}); err != nil {
-				return err
+		return err
+	}
+	tagIter, err := r.Tags()
+	if err != nil {
+		return err
+	}
+	err = tagIter.ForEach(func(ref *plumbing.Reference) error {
+		tag, err := r.TagObject(ref.Hash())
+		if err == plumbing.ErrObjectNotFound { // 轻量标签直接指向commit
+			_, err = r.CommitObject(ref.Hash())
+			if err != nil {
+				return fmt.Errorf("在线仓库的标签%s指向的不是commit", ref.Name().Short())
 			}
-			err = r.Push(&git.PushOptions{
-				Force: true,
-				Auth: &http.BasicAuth{
-					Username: g.UserName,
-					Password: g.Password,
-				}})
-			if err == nil || err.Error() == "already up-to-date" {
-				if err == nil {
-					fmt.Println("git", g.Url, "中的内容已被成功清除", "使用的账户为", g.UserName)
-				}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if tag.TargetType != plumbing.CommitObject {
+			return fmt.Errorf("在线仓库的标签%s指向的不是commit", ref.Name().Short())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	cIter, err := r.CommitObjects()
+	if err != nil {
+		return err
+	}
+	err = cIter.ForEach(func(c *object.Commit) error {
+		if c.NumParents() > 0 {
+			return fmt.Errorf("在线仓库中仍然有历史commit %s", c.Hash)
+		}
+		tree, err := c.Tree()
+		if err != nil {
+			return err
+		}
+		if len(tree.Entries) > 0 {
+			return fmt.Errorf("在线仓库的commit %s中仍然有文件", c.Hash)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	_, extraRefNameList, err := g.listRemoteRefs(remote)
+	if err != nil {
+		return err
+	}
+	if len(extraRefNameList) > 0 {
+		err = fmt.Errorf("在线仓库中仍然有%d个本工具创建的分支", len(extraRefNameList))
+		return err
+	}
+	return err
+}
+
+// 只删除在线仓库中指定的文件,不影响仓库中的其他文件.
+//  在线仓库是与其他发送方共用的,删除提交在取回的默认分支之上,并且不强制push:
+//  如果在取回之后其他发送方又push了新的文件,push会因为不是fast-forward而失败,不会覆盖别人的文件
+func (g Git) RemoveFromRepository(fileNameList []string) error {
+	var err error
+	defer func() {
+		if err != nil {
+			fmt.Println("无法从仓库中删除文件", err)
+		}
+	}()
+	err = g.CloneRepository()
+	if err != nil {
+		return err
+	}
+	r, err := g.openWorkRepository()
+	if err != nil {
+		return err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	// 使用分支模式上传的文件在单独的分支中,删除只包含这些文件的分支
+	err = g.removeTransferBranches(r, fileNameList)
+	if err != nil {
+		return err
+	}
+	var removedNum int
+	for _, fileName := range fileNameList {
+		if _, err := w.Filesystem.Stat(fileName); err != nil { // 已经被删除了
+			continue
+		}
+		_, err = w.Remove(fileName)
+		if err != nil {
+			return err
+		}
+		removedNum++
+	}
+	if removedNum == 0 {
+		return err
+	}
+	err = g.commit(r, w)
+	if err != nil {
+		return err
+	}
+	auth, err := g.auth()
+	if err != nil {
+		return err
+	}
+	err = r.Push(&git.PushOptions{
+		Auth: auth,
+	})
+	return err
+}
+
+// 删除以BranchPrefix开头、并且相对于默认分支新增的文件全部在fileNameList中的分支
+func (g Git) removeTransferBranches(r *git.Repository, fileNameList []string) error {
+	branchRefList, err := g.fetchTransferBranches(r)
+	if err != nil || len(branchRefList) == 0 {
+		return err
+	}
+	fileNameSet := make(map[string]bool)
+	for _, fileName := range fileNameList {
+		fileNameSet[fileName] = true
+	}
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	headTree, err := getCommitTree(r, head.Hash())
+	if err != nil {
+		return err
+	}
+	var refSpecList []config.RefSpec
+	for _, ref := range branchRefList {
+		tree, err := getCommitTree(r, ref.Hash())
+		if err != nil {
+			return err
+		}
+		isRemovable := true
+		err = tree.Files().ForEach(func(f *object.File) error {
+			if _, err := headTree.File(f.Name); err == nil { // 默认分支中也有的文件
 				return nil
 			}
-			err = filetools.RmDir(g.RepoDir)
+			if !fileNameSet[f.Name] {
+				isRemovable = false
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if isRemovable {
+			refSpecList = append(refSpecList, config.RefSpec(":"+ref.Name().String()))
 		}
 	}
+	if len(refSpecList) == 0 {
+		return err
+	}
+	auth, err := g.auth()
+	if err != nil {
+		return err
+	}
+	err = r.Push(&git.PushOptions{
+		RefSpecs: refSpecList,
+		Auth:     auth,
+	})
 	return err
 }
 
-// 检查远端是不是有新的commit
-func isRepoHasRemoteCommits(r *git.Repository) (bool, error) {
+// 检查账号是否可用:用账号的凭据建立一个push会话并读取远端的引用,
+//  需要写权限才能成功,但不会向仓库写入任何内容
+func (g Git) CheckHealth() error {
 	var err error
 	defer func() {
 		if err != nil {
-			fmt.Println("无法检查远端是不是有新的commit", err)
+			fmt.Println("git账号", g.UserName, "在", g.Url, "不可用", err)
 		}
 	}()
-	err = r.Fetch(&git.FetchOptions{
-		RemoteName: "origin",
-	})
+	endpoint, err := transport.NewEndpoint(g.Url)
 	if err != nil {
-		return false, err
+		return err
 	}
-	remoteLastcommitHash, err := r.ResolveRevision(plumbing.Revision("origin/master"))
+	c, err := client.NewClient(endpoint)
 	if err != nil {
-		return false, err
+		return err
 	}
-	localLastcommitHash, err := r.ResolveRevision(plumbing.Revision("master"))
+	auth, err := g.auth()
 	if err != nil {
-		return false, err
+		return err
 	}
-	return !(remoteLastcommitHash == localLastcommitHash), err
+	session, err := c.NewReceivePackSession(endpoint, auth)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	_, err = session.AdvertisedReferences()
+	if err == transport.ErrEmptyRemoteRepository { // 空仓库也是可用的
+		err = nil
+	}
+	return err
 }
@@ -0,0 +1,44 @@
+package gittools
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSignature(t *testing.T) {
+	testCaseList := []struct {
+		name        string
+		g           Git
+		wantName    string
+		wantEmail   string
+		emailSuffix string
+	}{
+		{"配置了作者信息", Git{Url: "https://github.com/a/b", CommitStyle: CommitStyle{AuthorName: "Bob", AuthorEmail: "bob@example.com"}}, "Bob", "bob@example.com", ""},
+		{"只配置了邮箱", Git{Url: "https://github.com/a/b", CommitStyle: CommitStyle{AuthorEmail: "carol@example.com"}}, "carol", "carol@example.com", ""},
+		{"使用账号的用户名", Git{Url: "https://github.com/a/b", UserName: "dave"}, "dave", "dave@users.noreply.github.com", ""},
+		{"作者名中有空格", Git{Url: "https://github.com/a/b", CommitStyle: CommitStyle{AuthorName: "Eve Li"}}, "Eve Li", "eve-li@users.noreply.github.com", ""},
+		{"令牌认证没有用户名", Git{Url: "https://github.com/a/b"}, "", "", "@users.noreply.github.com"},
+		{"ssh认证的用户名git", Git{Url: "ssh://git@gitee.com/a/b", UserName: "git"}, "", "", "@users.noreply.gitee.com"},
+		{"作者名只有符号", Git{Url: "https://github.com/a/b", CommitStyle: CommitStyle{AuthorName: "李雷"}}, "李雷", DefaultAuthorNameList[0] + "@users.noreply.github.com", ""},
+	}
+	for _, testCase := range testCaseList {
+		t.Run(testCase.name, func(t *testing.T) {
+			signature := testCase.g.signature()
+			if signature.Name == "" || strings.HasPrefix(signature.Email, "@") {
+				t.Fatalf("作者信息为空: %q <%s>", signature.Name, signature.Email)
+			}
+			if testCase.wantName != "" && signature.Name != testCase.wantName {
+				t.Errorf("作者名为%q,应为%q", signature.Name, testCase.wantName)
+			}
+			if testCase.wantEmail != "" && signature.Email != testCase.wantEmail {
+				t.Errorf("邮箱为%q,应为%q", signature.Email, testCase.wantEmail)
+			}
+			if !strings.HasSuffix(signature.Email, testCase.emailSuffix) {
+				t.Errorf("邮箱%q没有以%q结尾", signature.Email, testCase.emailSuffix)
+			}
+			if again := testCase.g.signature(); again.Name != signature.Name || again.Email != signature.Email {
+				t.Errorf("同一个仓库的作者信息不固定: %q和%q", signature.Name, again.Name)
+			}
+		})
+	}
+}
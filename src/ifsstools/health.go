@@ -0,0 +1,90 @@
+package ifsstools
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+	"xindauserbackground/src/filetools"
+	"xindauserbackground/src/jsontools"
+	"xindauserbackground/src/workerpool"
+)
+
+// 健康检查结果的有效期,有效期内不再重复检查
+const HealthCheckLifetime = 5 * time.Minute
+
+// 一个账号的健康检查结果
+type healthRecord struct {
+	err       error // 为nil表示可用
+	checkTime time.Time
+}
+
+// 所有账号的健康检查结果,key为IFSS类型/URL/用户名
+var healthCache sync.Map
+
+// 生成账号在健康检查缓存中的key
+func getHealthCacheKey(accountParser *jsontools.JsonParser) string {
+	return accountParser.ReadJsonValue("/IFSSType").(string) + " " + accountParser.ReadJsonValue("/IFSSURL").(string) + " " + accountParser.ReadJsonValue("/IFSSUserName").(string)
+}
+
+// 检查IFSS账号是否可用(登录并用不写入内容的请求探测),有效期内直接使用缓存的结果
+func CheckAccountHealth(accountParser *jsontools.JsonParser, workDir string) error {
+	var err error
+	key := getHealthCacheKey(accountParser)
+	if record, isExist := healthCache.Load(key); isExist && time.Since(record.(healthRecord).checkTime) < HealthCheckLifetime {
+		return record.(healthRecord).err
+	}
+	ifssType := accountParser.ReadJsonValue("/IFSSType").(string)
+	switch ifssType {
+	case "git":
+		g := newGitClient(accountParser, "")
+		err = g.CheckHealth()
+	case "webdav":
+		localDir := filepath.Join(workDir, ".health_check_"+filetools.GenerateRandomFileName(filetools.RandomFileNameLength))
+		w := newWebdavClient(accountParser, localDir)
+		err = w.CheckHealth()
+		filetools.RmDir(localDir)
+	default:
+		err = fmt.Errorf("IFSS类型%s不合法", ifssType)
+	}
+	healthCache.Store(key, healthRecord{err, time.Now()})
+	return err
+}
+
+// 上传失败时把账号标记为不可用,有效期内不再分配文件给它
+func markAccountUnhealthy(accountParser *jsontools.JsonParser, err error) {
+	healthCache.Store(getHealthCacheKey(accountParser), healthRecord{err, time.Now()})
+}
+
+// 返回可用的账号.
+//  没有失败记录的账号直接认为可用,不做探测,以免每次上传前所有账号同时出现一次探测流量;
+//  只有上传失败过并且记录已经过期的账号才重新探测,探测通过workerpool并发执行
+func filterHealthyAccountList(accountParserList []*jsontools.JsonParser, workDir string) []*jsontools.JsonParser {
+	errList := make([]error, len(accountParserList))
+	var probeIndexList []int
+	for i, accountParser := range accountParserList {
+		record, isExist := healthCache.Load(getHealthCacheKey(accountParser))
+		if !isExist || record.(healthRecord).err == nil {
+			continue
+		}
+		if time.Since(record.(healthRecord).checkTime) < HealthCheckLifetime {
+			errList[i] = record.(healthRecord).err
+			continue
+		}
+		probeIndexList = append(probeIndexList, i)
+	}
+	workerpool.Run(0, len(probeIndexList), func(j int) error {
+		i := probeIndexList[j]
+		errList[i] = CheckAccountHealth(accountParserList[i], workDir)
+		return nil
+	})
+	var healthyAccountParserList []*jsontools.JsonParser
+	for i, accountParser := range accountParserList {
+		if errList[i] == nil {
+			healthyAccountParserList = append(healthyAccountParserList, accountParser)
+		} else {
+			fmt.Println("跳过不可用的IFSS账号", accountParser.ReadJsonValue("/IFSSName").(string))
+		}
+	}
+	return healthyAccountParserList
+}
@@ -5,16 +5,110 @@ import (
 	"fmt"
 	"path/filepath"
 	"sync"
+	"time"
+	"xindauserbackground/src/crypto/envelope"
 	"xindauserbackground/src/crypto/rsatools"
 	"xindauserbackground/src/filetools"
 	"xindauserbackground/src/ifsstools/gittools"
 	"xindauserbackground/src/ifsstools/webdavtools"
 	"xindauserbackground/src/jsontools"
+	"xindauserbackground/src/workerpool"
 	"xindauserbackground/src/ziptools"
 )
 
-// 上传文件夹中的数据交换文件到IFSS
-func UploadToIFSS(sendFolderDir string, neighborJsonParser *jsontools.JsonParser, sendProgressChannel chan []byte) error {
+// 需要转发给下一跳节点的数据交换文件存放在receiveDir下的这个文件夹中
+const ForwardFolderName = ".forward"
+
+// 根据接入节点的信息生成路由上的所有节点,第一个节点是接入节点本身,之后是RelayList中的中继节点
+func readHopList(neighborJsonParser *jsontools.JsonParser) ([]envelope.Hop, error) {
+	var hopList []envelope.Hop
+	hopParserList := append([]*jsontools.JsonParser{neighborJsonParser}, neighborJsonParser.GetAllChildren("RelayList")...)
+	for _, hopParser := range hopParserList {
+		var hopName string
+		if hopParser.IsPathExists("/Name") {
+			hopName = hopParser.ReadJsonValue("/Name").(string)
+		}
+		hopPublicKey, err := rsatools.StringToPublicKey(hopParser.ReadJsonValue("/PublicKey").(string))
+		if err != nil {
+			return nil, err
+		}
+		hopList = append(hopList, envelope.Hop{Name: hopName, PublicKey: hopPublicKey})
+	}
+	return hopList, nil
+}
+
+// 读取信封的有效期(秒),没有配置则使用默认值
+func readEnvelopeLifetime(neighborJsonParser *jsontools.JsonParser) time.Duration {
+	if neighborJsonParser.IsPathExists("/EnvelopeLifetime") {
+		return time.Duration(neighborJsonParser.ReadJsonValue("/EnvelopeLifetime").(float64)) * time.Second
+	}
+	return envelope.DefaultLifetime
+}
+
+// 根据IFSS账号的配置生成git连接,repoDir为本地仓库的位置
+func newGitClient(accountParser *jsontools.JsonParser, repoDir string) gittools.Git {
+	ifssURL := accountParser.ReadJsonValue("/IFSSURL").(string)
+	ifssUserName := accountParser.ReadJsonValue("/IFSSUserName").(string)
+	ifssPassword := accountParser.ReadJsonValue("/IFSSUserPassword").(string)
+	g := gittools.NewGitClient(ifssURL, repoDir, ifssUserName, ifssPassword)
+	g.MaxFileNumPerPush = readAccountLimit(accountParser).MaxFileNumPerPush
+	// 认证方式,没有配置时使用用户名和密码
+	if accountParser.IsPathExists("/IFSSAuthType") {
+		g.AuthType = accountParser.ReadJsonValue("/IFSSAuthType").(string)
+	}
+	if accountParser.IsPathExists("/IFSSSSHKeyPath") {
+		g.SSHKeyPath = accountParser.ReadJsonValue("/IFSSSSHKeyPath").(string)
+	}
+	if accountParser.IsPathExists("/IFSSSSHKeyPassphrase") {
+		g.SSHKeyPassphrase = accountParser.ReadJsonValue("/IFSSSSHKeyPassphrase").(string)
+	}
+	if accountParser.IsPathExists("/IFSSSSHKnownHostsPath") {
+		g.SSHKnownHostsPath = accountParser.ReadJsonValue("/IFSSSSHKnownHostsPath").(string)
+	}
+	if accountParser.IsPathExists("/InMemory") {
+		g.InMemory = accountParser.ReadJsonValue("/InMemory").(bool)
+	}
+	if accountParser.IsPathExists("/BranchMode") {
+		g.BranchMode = accountParser.ReadJsonValue("/BranchMode").(string)
+	}
+	// commit的作者信息、时间和提交说明
+	if accountParser.IsPathExists("/CommitAuthorName") {
+		g.CommitStyle.AuthorName = accountParser.ReadJsonValue("/CommitAuthorName").(string)
+	}
+	if accountParser.IsPathExists("/CommitAuthorEmail") {
+		g.CommitStyle.AuthorEmail = accountParser.ReadJsonValue("/CommitAuthorEmail").(string)
+	}
+	if accountParser.IsPathExists("/CommitMessageList") {
+		for _, message := range accountParser.ReadJsonValue("/CommitMessageList").([]interface{}) {
+			g.CommitStyle.MessageList = append(g.CommitStyle.MessageList, message.(string))
+		}
+	}
+	g.CommitStyle.TimeJitter = readDurationSecond(accountParser, "/CommitTimeJitter", 0)
+	return g
+}
+
+// 按照账号配置生成Webdav连接,没有配置存储文件夹时使用默认的文件夹
+func newWebdavClient(accountParser *jsontools.JsonParser, localDir string) webdavtools.Webdav {
+	ifssURL := accountParser.ReadJsonValue("/IFSSURL").(string)
+	ifssUserName := accountParser.ReadJsonValue("/IFSSUserName").(string)
+	ifssPassword := accountParser.ReadJsonValue("/IFSSUserPassword").(string)
+	w := webdavtools.NewWebdavClient(ifssURL, localDir, ifssUserName, ifssPassword)
+	if accountParser.IsPathExists("/WebdavBasePath") {
+		w.WebdavDir = accountParser.ReadJsonValue("/WebdavBasePath").(string)
+	}
+	if accountParser.IsPathExists("/WebdavChunkUploadUrl") {
+		w.ChunkUploadUrl = accountParser.ReadJsonValue("/WebdavChunkUploadUrl").(string)
+		w.ChunkSize = webdavtools.DefaultChunkSize
+	}
+	if accountParser.IsPathExists("/WebdavChunkSize") {
+		w.ChunkSize = int64(accountParser.ReadJsonValue("/WebdavChunkSize").(float64))
+	}
+	return w
+}
+
+// 上传文件夹中的数据交换文件到IFSS,每个数据交换文件和它的路由信封打包在一起.
+//  发送阶段配置中设置了UploadWindow时,上传会被随机地分散到这个时间窗口内;AssignStrategy决定文件如何分配给各个账号
+func UploadToIFSS(sendFolderDir string, neighborJsonParser, sendStrategyJsonParser *jsontools.JsonParser, sendProgressChannel chan []byte) error {
 	var err error
 	if !filetools.IsPathExists(sendFolderDir) || filetools.IsFolderEmpty(sendFolderDir) {
 		err = fmt.Errorf("无法在文件夹中找到需要上传到IFSS的文件")
@@ -22,181 +116,480 @@ func UploadToIFSS(sendFolderDir string, neighborJsonParser *jsontools.JsonParser
 	}
 	fmt.Println("在", sendFolderDir, "中找到了需要上传到IFSS的文件")
 	_, receiverName := filepath.Split(sendFolderDir)
-	neighborPublicKeyString := neighborJsonParser.ReadJsonValue("/PublicKey").(string)
-	neighborPublicKey, err := rsatools.StringToPublicKey(neighborPublicKeyString)
-	receiverAccountParserList := neighborJsonParser.GetAllChildren("OwnAccountList")
+	hopList, err := readHopList(neighborJsonParser)
+	if err != nil {
+		return err
+	}
+	envelopeLifetime := readEnvelopeLifetime(neighborJsonParser)
 	filePathList, _, _ := filetools.GenerateUnhiddenFilePathNameListFromFolder(sendFolderDir)
-	filePathGroup := filetools.DivideDirListToGroup(filePathList, len(receiverAccountParserList))
-	uploadGoroutine := func(wg *sync.WaitGroup, children *jsontools.JsonParser, filePathList []string) {
-		defer wg.Done()
-		ifssName := children.ReadJsonValue("/IFSSName").(string)
-		ifssFolderDir := filepath.Join(sendFolderDir, ifssName)
-		// 生成一个新的文件夹,使用该IFSS账号的所有文件都储存在这个文件夹中
-		err = filetools.Mkdir(ifssFolderDir)
+	// 每个数据交换文件使用各自独立生成的路由信封
+	generateEnvelope := func(filePath string) ([]byte, error) {
+		return envelope.Seal(receiverName, hopList, envelopeLifetime, envelope.DefaultMaxPaddingSize)
+	}
+	strategy, err := readAssignStrategy(sendStrategyJsonParser, sendFolderDir)
+	if err != nil {
+		return err
+	}
+	option := uploadOption{
+		uploadWindow:    readUploadWindow(sendStrategyJsonParser),
+		strategy:        strategy,
+		envelopeMaxSize: envelope.EstimateMaxSize(receiverName, hopList, envelope.DefaultMaxPaddingSize),
+		workerNum:       workerpool.ReadWorkerNum(sendStrategyJsonParser),
+	}
+	return uploadToAccountList(sendFolderDir, filePathList, neighborJsonParser.GetAllChildren("OwnAccountList"), generateEnvelope, option, sendProgressChannel)
+}
+
+// 上传时的可选配置,零值表示立即上传并均匀随机分配
+type uploadOption struct {
+	uploadWindow    time.Duration  // 为0时所有账号立即上传,否则每个账号和每个数据交换文件都在时间窗口内随机延迟上传
+	strategy        assignStrategy // 数据交换文件分配给IFSS账号的策略
+	envelopeMaxSize int            // 路由信封的最大长度,用来估计打包后的大小
+	workerNum       int            // 同时上传的账号数量以及每个账号同时打包/上传的文件数量,为0时使用默认的并发数量
+}
+
+// 将数据交换文件和路由信封打包后,按照分配策略分配给各个可用的IFSS账号上传.
+//  某个账号上传失败时,把它的文件重新分配给其他可用的账号.每个账号的结果都会反馈到sendProgressChannel,
+//  有文件没能上传时返回*MultiError
+func uploadToAccountList(sendFolderDir string, filePathList []string, receiverAccountParserList []*jsontools.JsonParser, generateEnvelope func(filePath string) ([]byte, error), option uploadOption, sendProgressChannel chan []byte) error {
+	var err error
+	accountParserList := filterHealthyAccountList(receiverAccountParserList, sendFolderDir)
+	if len(accountParserList) == 0 {
+		err = fmt.Errorf("没有可用的IFSS账号")
+		fmt.Println(err)
+		return err
+	}
+	// 在分配之前估计每个文件打包后的大小,检查账号的大小限制
+	filePath_UploadSizeMap, err := estimateUploadSizeMap(filePathList, option.envelopeMaxSize)
+	if err != nil {
+		return err
+	}
+	filePathGroup, fileName_OriginMap, err := assignFileListToAccountList(filePathList, accountParserList, option.strategy, filePath_UploadSizeMap, nil)
+	if err != nil {
+		return err
+	}
+	resultList := newAccountResultList(accountParserList)
+	zipErrList := make([]error, len(accountParserList))
+	uploadErrList := make([]error, len(accountParserList))
+	zipFileNameGroup := make([][]string, len(accountParserList))
+	// 每个账号的错误记录在各自的结果中,任务本身不返回错误,以免一个账号出错时其他账号停止上传
+	uploadTask := func(i int, filePathList []string) error {
+		children := accountParserList[i]
+		ifssFolderDir := filepath.Join(sendFolderDir, resultList[i].IFSSName)
+		resultList[i].AttemptNum += len(filePathList)
+		zipFileNameGroup[i], zipErrList[i] = zipToAccountFolder(ifssFolderDir, filePathList, generateEnvelope, option.workerNum)
+		if zipErrList[i] != nil {
+			resultList[i].Err = zipErrList[i]
+			return nil
+		}
+		counter := newProgressCounter(sendProgressChannel)
+		uploadErrList[i] = uploadAccountFolder(children, ifssFolderDir, zipFileNameGroup[i], option.uploadWindow, option.workerNum, counter.channel)
+		resultList[i].SuccessNum += counter.stop()
+		resultList[i].Err = uploadErrList[i]
+		return nil
+	}
+	pool := workerpool.NewPool(option.workerNum)
+	for i, filePathList := range filePathGroup {
+		if len(filePathList) == 0 { // 没有分配到文件的账号不需要上传
+			continue
+		}
+		i, filePathList := i, filePathList
+		pool.Go(func() error { return uploadTask(i, filePathList) }) // 将list中的所有文件打包后上传到这个平台
+	}
+	pool.Wait()
+	for _, zipErr := range zipErrList {
+		if zipErr != nil {
+			err = zipErr
+		}
+	}
+	failoverErr := failoverUpload(sendFolderDir, accountParserList, zipFileNameGroup, fileName_OriginMap, uploadErrList, resultList, sendProgressChannel)
+	if failoverErr != nil {
+		err = failoverErr
+	}
+	sendAccountResultList(MSG_UPLOAD_RESULT, resultList, sendProgressChannel)
+	// err = filetools.RmDir(sendFolderDir) // 删除本地文件,销毁上传记录
+	if err != nil {
+		return &MultiError{Err: err, ResultList: resultList}
+	}
+	return err
+}
+
+// 将数据交换文件和路由信封打包,存放到使用该IFSS账号的文件夹中,返回打包好的文件名.
+//  最多workerNum个文件同时打包
+func zipToAccountFolder(ifssFolderDir string, filePathList []string, generateEnvelope func(filePath string) ([]byte, error), workerNum int) ([]string, error) {
+	var err error
+	// 生成一个新的文件夹,使用该IFSS账号的所有文件都储存在这个文件夹中
+	err = filetools.Mkdir(ifssFolderDir)
+	if err != nil {
+		return nil, err
+	}
+	zippedFileNameList := make([]string, len(filePathList))
+	err = workerpool.Run(workerNum, len(filePathList), func(i int) error {
+		_, fileName := filepath.Split(filePathList[i])
+		envelopeBytes, err := generateEnvelope(filePathList[i])
 		if err != nil {
-			return
-		}
-		for _, filePath := range filePathList {
-			_, fileName := filepath.Split(filePath)
-			// 将数据交换文件移动到新的文件夹
-			tempFolderDir := filepath.Join(ifssFolderDir, fileName+"_ready_to_zip")
-			specFilePath := filepath.Join(tempFolderDir, fileName)
-			filetools.Rename(filePath, specFilePath)
-			encryptedReceiverName, err := rsatools.EncryptWithPublicKey([]byte(receiverName), neighborPublicKey)
-			if err != nil {
-				return
+			return err
+		}
+		// 在内存中把数据交换文件和路由信封打包,只把打包好的文件写入该IFSS账号的文件夹
+		specFileBytes, err := filetools.ReadFile(filePathList[i])
+		if err != nil {
+			return err
+		}
+		zipBytes, err := ziptools.ZipBytes([]string{fileName, fileName + "_"}, [][]byte{specFileBytes, envelopeBytes})
+		if err != nil {
+			return err
+		}
+		err = filetools.WriteFile(filepath.Join(ifssFolderDir, fileName), zipBytes, 0777)
+		if err != nil {
+			return err
+		}
+		err = filetools.RmFile(filePathList[i])
+		if err != nil {
+			return err
+		}
+		zippedFileNameList[i] = fileName
+		return nil
+	})
+	var zipFileNameList []string
+	for _, zipFileName := range zippedFileNameList {
+		if zipFileName != "" {
+			zipFileNameList = append(zipFileNameList, zipFileName)
+		}
+	}
+	return zipFileNameList, err
+}
+
+// 将文件夹中打包好的文件上传到IFSS平台,成功后删除文件夹
+func uploadAccountFolder(children *jsontools.JsonParser, ifssFolderDir string, zipFileNameList []string, uploadWindow time.Duration, workerNum int, sendProgressChannel chan []byte) error {
+	var err error
+	var zipFilePathList []string
+	for _, zipFileName := range zipFileNameList {
+		zipFilePathList = append(zipFilePathList, filepath.Join(ifssFolderDir, zipFileName))
+	}
+	err = checkAccountLimit(children, zipFilePathList)
+	if err != nil {
+		return err
+	}
+	if uploadWindow > 0 {
+		err = uploadWithJitter(children, ifssFolderDir, zipFileNameList, uploadWindow, sendProgressChannel)
+		if err != nil {
+			return err
+		}
+		return filetools.RmDir(ifssFolderDir)
+	}
+	// 使用IFSS账号将本地文件上传到IFSS平台
+	ifssType := children.ReadJsonValue("/IFSSType").(string)
+	switch ifssType {
+	case "git":
+		g := newGitClient(children, ifssFolderDir)
+		err = g.CloneRepository()
+		if err != nil {
+			return err
+		}
+		err = g.PushToRepository(sendProgressChannel)
+		if err != nil {
+			return err
+		}
+	case "webdav":
+		w := newWebdavClient(children, ifssFolderDir)
+		w.WorkerNum = workerNum
+		err = w.UploadAllFilesFromFolder(sendProgressChannel)
+		if err != nil {
+			return err
+		}
+	default:
+		panic("IFSS类型错误")
+	}
+	return filetools.RmDir(ifssFolderDir) // 删除IFSS上传使用的文件夹
+}
+
+// 故障转移:把上传失败的账号中已经打包好的文件重新分配给其他可用的账号,
+//  直到全部上传成功,或者没有可用的账号时返回错误.上传失败的账号会被标记为不可用,
+//  只是超出了大小限制的账号仍然可用,不会被标记.
+//  fileName_OriginMap为副本文件名对应的原文件名,重新分配时避开已经保存了同一个文件其他副本的账号,
+//  其余账号都已经保存了这个文件时不再重新上传
+func failoverUpload(sendFolderDir string, accountParserList []*jsontools.JsonParser, zipFileNameGroup [][]string, fileName_OriginMap map[string]string, uploadErrList []error, resultList []AccountResult, sendProgressChannel chan []byte) error {
+	var err error
+	failedSet := make(map[int]bool)
+	getOriginFileName := func(fileName string) string {
+		if originFileName, isExist := fileName_OriginMap[fileName]; isExist {
+			return originFileName
+		}
+		return fileName
+	}
+	// 原文件名对应保存了这个文件(原文件或副本)的账号
+	originFileName_HolderSetMap := make(map[string]map[int]bool)
+	addHolder := func(fileName string, i int) {
+		originFileName := getOriginFileName(fileName)
+		if originFileName_HolderSetMap[originFileName] == nil {
+			originFileName_HolderSetMap[originFileName] = make(map[int]bool)
+		}
+		originFileName_HolderSetMap[originFileName][i] = true
+	}
+	for i, zipFileNameList := range zipFileNameGroup {
+		for _, zipFileName := range zipFileNameList {
+			addHolder(zipFileName, i)
+		}
+	}
+	for {
+		var zipFilePathList []string
+		for i, uploadErr := range uploadErrList {
+			if uploadErr == nil {
+				continue
 			}
-			infoFilePath := filepath.Join(tempFolderDir, fileName+"_")
-			err = filetools.WriteFile(infoFilePath, encryptedReceiverName, 0777)
-			if err != nil {
-				return
+			failedSet[i] = true
+			if !isLimitError(uploadErr) {
+				markAccountUnhealthy(accountParserList[i], uploadErr)
 			}
-			tempFilePathList := append([]string{}, specFilePath, infoFilePath)
-			zipFilePath := filepath.Join(ifssFolderDir, fileName)
-			err = ziptools.ZipFiles(tempFilePathList, zipFilePath)
-			if err != nil {
-				return
+			ifssName := accountParserList[i].ReadJsonValue("/IFSSName").(string)
+			fmt.Println("IFSS账号", ifssName, "上传失败,将它的", len(zipFileNameGroup[i]), "个文件重新分配给其他账号")
+			for _, zipFileName := range zipFileNameGroup[i] {
+				zipFilePathList = append(zipFilePathList, filepath.Join(sendFolderDir, ifssName, zipFileName))
 			}
-			err = filetools.RmDir(tempFolderDir)
-			if err != nil {
-				return
+			err = uploadErr
+		}
+		if len(zipFilePathList) == 0 {
+			return nil
+		}
+		var remainIndexList []int
+		var remainAccountParserList []*jsontools.JsonParser
+		for i, accountParser := range accountParserList {
+			if !failedSet[i] {
+				remainIndexList = append(remainIndexList, i)
+				remainAccountParserList = append(remainAccountParserList, accountParser)
 			}
 		}
-		// 使用IFSS账号将本地文件上传到IFSS平台
-		ifssType := children.ReadJsonValue("/IFSSType").(string)
-		ifssURL := children.ReadJsonValue("/IFSSURL").(string)
-		ifssUserName := children.ReadJsonValue("/IFSSUserName").(string)
-		ifssPassword := children.ReadJsonValue("/IFSSUserPassword").(string)
-		switch ifssType {
-		case "git":
-			g := gittools.NewGitClient(ifssURL, ifssFolderDir, ifssUserName, ifssPassword)
-			err = g.CloneRepository()
-			if err != nil {
-				return
+		if len(remainAccountParserList) == 0 {
+			err = fmt.Errorf("所有IFSS账号都上传失败,最后一个错误为: %v", err)
+			fmt.Println(err)
+			return err
+		}
+		// 同一个文件的副本共用一个集合,记录其余账号中已经保存了它的账号;
+		// 其余账号都已经(或者将要)保存这个文件时,多出来的副本不再上传
+		var reassignFilePathList []string
+		zipFilePath_ForbiddenSetMap := make(map[string]map[int]bool)
+		originFileName_ForbiddenSetMap := make(map[string]map[int]bool)
+		originFileName_ReassignNumMap := make(map[string]int)
+		for _, zipFilePath := range zipFilePathList {
+			originFileName := getOriginFileName(filepath.Base(zipFilePath))
+			forbiddenSet, isExist := originFileName_ForbiddenSetMap[originFileName]
+			if !isExist {
+				forbiddenSet = make(map[int]bool)
+				for j, i := range remainIndexList {
+					if originFileName_HolderSetMap[originFileName][i] {
+						forbiddenSet[j] = true
+					}
+				}
+				originFileName_ForbiddenSetMap[originFileName] = forbiddenSet
 			}
-			err = g.PushToRepository(sendProgressChannel)
-			if err != nil {
-				return
+			if len(forbiddenSet)+originFileName_ReassignNumMap[originFileName] >= len(remainIndexList) {
+				fmt.Println("其余IFSS账号都已经保存了", filepath.Base(zipFilePath), "的副本,不再重新上传")
+				continue
 			}
-		case "webdav":
-			w := webdavtools.NewWebdavClient(ifssURL, ifssFolderDir, ifssUserName, ifssPassword)
-			err = w.UploadAllFilesFromFolder(sendProgressChannel)
+			originFileName_ReassignNumMap[originFileName]++
+			zipFilePath_ForbiddenSetMap[zipFilePath] = forbiddenSet
+			reassignFilePathList = append(reassignFilePathList, zipFilePath)
+		}
+		if len(reassignFilePathList) == 0 {
+			for i := range failedSet {
+				filetools.RmDir(filepath.Join(sendFolderDir, accountParserList[i].ReadJsonValue("/IFSSName").(string)))
+			}
+			return nil
+		}
+		var zipFilePath_SizeMap map[string]int64
+		zipFilePath_SizeMap, err = estimateUploadSizeMap(reassignFilePathList, 0)
+		if err != nil {
+			return err
+		}
+		var zipFilePathGroup [][]string
+		zipFilePathGroup, _, err = assignFileListToAccountList(reassignFilePathList, remainAccountParserList, assignStrategy{}, zipFilePath_SizeMap, zipFilePath_ForbiddenSetMap)
+		if err != nil {
+			return err
+		}
+		uploadErrList = make([]error, len(accountParserList))
+		pool := workerpool.NewPool(0)
+		for j, zipFilePathList := range zipFilePathGroup {
+			if len(zipFilePathList) == 0 {
+				continue
+			}
+			i := remainIndexList[j]
+			ifssFolderDir := filepath.Join(sendFolderDir, accountParserList[i].ReadJsonValue("/IFSSName").(string))
+			zipFileNameGroup[i] = nil
+			for _, zipFilePath := range zipFilePathList {
+				zipFileNameGroup[i] = append(zipFileNameGroup[i], filepath.Base(zipFilePath))
+				addHolder(filepath.Base(zipFilePath), i)
+			}
+			err = filetools.MoveFilesToNewFolder(zipFilePathList, ifssFolderDir)
 			if err != nil {
-				return
+				return err
 			}
-		default:
-			panic("IFSS类型错误")
+			resultList[i].AttemptNum += len(zipFileNameGroup[i])
+			pool.Go(func() error {
+				counter := newProgressCounter(sendProgressChannel)
+				uploadErrList[i] = uploadAccountFolder(accountParserList[i], ifssFolderDir, zipFileNameGroup[i], 0, 0, counter.channel)
+				resultList[i].SuccessNum += counter.stop()
+				if uploadErrList[i] != nil {
+					resultList[i].Err = uploadErrList[i]
+				}
+				return nil
+			})
+		}
+		pool.Wait()
+		for i := range failedSet { // 删除上传失败的账号使用的文件夹
+			filetools.RmDir(filepath.Join(sendFolderDir, accountParserList[i].ReadJsonValue("/IFSSName").(string)))
 		}
-		filetools.RmDir(ifssFolderDir) // 删除IFSS上传使用的文件夹
-	}
-	var wg sync.WaitGroup // 信号量
-	wg.Add(len(filePathGroup))
-	for i, filePathList := range filePathGroup {
-		children := receiverAccountParserList[i]        // 被选中的IFSS平台
-		go uploadGoroutine(&wg, children, filePathList) // 将list中的所有文件打包后上传到这个平台
 	}
-	// for _, filePath := range filePathList {
-	// 	filetools.RmFile(filePath)
-	// }
-	wg.Wait()
-	// err = filetools.RmDir(sendFolderDir) // 删除本地文件,销毁上传记录
-	return err
 }
 
-// 从IFSS下载数据交换文件到receiveDir的以最终接收者命名的文件夹中
+// 从IFSS下载数据交换文件到receiveDir的以最终接收者命名的文件夹中.
+//  本节点只打开路由信封的最外层,如果本节点不是最后一跳,则把数据交换文件放到转发文件夹中.
+//  每个账号的结果都会反馈到receiveProgressChannel,有账号出错时返回*MultiError
 func DownloadFromIFSS(userPrivateKeyPath string, ownAccountListJsonParser *jsontools.JsonParser, receiveDir string, receiveProgressChannel chan []byte) ([]string, error) {
 	var err error
-	var saveDir string
 	type void struct{}
 	var voidMember void
 	saveDirListSet := make(map[string]void) // 为了去重
 	var saveDirList []string
+	specFileHashSet := make(map[string]void) // 同一个数据交换文件可能以不同的文件名上传到多个账号,按内容去重
+	var mutex sync.Mutex
 	userPrivateKey, err := rsatools.ReadPrivateKeyFile(userPrivateKeyPath)
 	if err != nil {
 		return nil, err
 	}
-	downloadGoroutine := func(wg *sync.WaitGroup, children *jsontools.JsonParser) {
-		defer wg.Done()
-		ifssName := children.ReadJsonValue("/IFSSName").(string)
-		ifssDownloadDir := filepath.Join(receiveDir, ifssName)
-		ifssType := children.ReadJsonValue("/IFSSType").(string)
-		ifssURL := children.ReadJsonValue("/IFSSURL").(string)
-		ifssUserName := children.ReadJsonValue("/IFSSUserName").(string)
-		ifssPassword := children.ReadJsonValue("/IFSSUserPassword").(string)
-		switch ifssType {
-		case "git":
-			g := gittools.NewGitClient(ifssURL, ifssDownloadDir, ifssUserName, ifssPassword)
-			// if err.Error() == "没有需要下载的内容" {
-			// 	return
-			// }
-			err = g.DownloadFromRepository(receiveProgressChannel)
-			// if err == nil {
-			// err = g.CleanRepository()
-			// }
-			// if err != nil {
-			// 	return
-			// }
-		case "webdav":
-			w := webdavtools.NewWebdavClient(ifssURL, ifssDownloadDir, ifssUserName, ifssPassword)
-			err = w.DownloadAllFilesToFolder(receiveProgressChannel)
-			// err = w.CleanWebdav()
-			// if err != nil {
-			// 	return
-			// }
-		default:
-			panic("IFSS类型错误")
+	// 处理下载到的一个zip文件:打开路由信封的最外层,把数据交换文件存到最终接收者的文件夹或者转发文件夹中
+	processZipBytes := func(fileName string, zipBytes []byte) error {
+		fileName_BytesMap, err := ziptools.UnzipBytes(zipBytes)
+		if err != nil {
+			return err
 		}
-		filePathList, _, err := filetools.GenerateUnhiddenFilePathNameListFromFolder(ifssDownloadDir)
-		if err != nil || filePathList == nil {
-			return
+		specFileBytes, isSpecFileExist := fileName_BytesMap[fileName]
+		envelopeBytes, isEnvelopeExist := fileName_BytesMap[fileName+"_"]
+		if !isSpecFileExist || !isEnvelopeExist {
+			return fmt.Errorf("无法在%s中找到数据交换文件和路由信封", fileName)
 		}
-		for _, filePath := range filePathList {
-			_, fileName := filepath.Split(filePath)
-			unzipFolderDir := filepath.Join(ifssDownloadDir, fileName+"_ziptemp")
-			err = filetools.Mkdir(unzipFolderDir)
-			if err != nil {
-				return
+		layer, err := envelope.Open(envelopeBytes, userPrivateKey)
+		if err != nil { // 不是发给本节点的文件,跳过
+			return nil
+		}
+		hash := filetools.GetBytesHash(specFileBytes)
+		mutex.Lock()
+		_, isDuplicate := specFileHashSet[hash]
+		specFileHashSet[hash] = voidMember
+		mutex.Unlock()
+		if isDuplicate { // 已经从其他账号下载过这个数据交换文件的副本
+			return nil
+		}
+		// 本节点不是最后一跳,把数据交换文件和剩下的信封放到转发文件夹中,等待转发给下一跳
+		if !layer.IsFinal() {
+			forwardDir := filepath.Join(receiveDir, ForwardFolderName, layer.NextHop)
+			if filetools.IsPathExists(filepath.Join(forwardDir, fileName)) {
+				return nil
 			}
-			_, err = ziptools.UnzipFile(filePath, unzipFolderDir)
+			err = filetools.WriteFile(filepath.Join(forwardDir, fileName+"_"), layer.Inner, 0777)
 			if err != nil {
-				return
+				return err
 			}
-			// 找到配置文件,并解出接收方是谁
-			specFilePath := filepath.Join(unzipFolderDir, fileName)
-			infoFilePath := filepath.Join(unzipFolderDir, fileName+"_")
-			encryptedReceiverNameBytes, err := filetools.ReadFile(infoFilePath)
+			err = writeHopLimit(filepath.Join(forwardDir, fileName), layer.HopLimit)
 			if err != nil {
-				return
+				return err
 			}
-			receiverNameBytes, err := rsatools.DecryptWithPrivateKey(encryptedReceiverNameBytes, userPrivateKey)
+			return filetools.WriteFile(filepath.Join(forwardDir, fileName), specFileBytes, 0777)
+		}
+		// 数据交换文件最终存储的文件夹位置,有多个接收方时只存一份,文件夹以所有接收方的名字命名
+		saveDir := filepath.Join(receiveDir, layer.ReceiverName)
+		if filetools.IsPathExists(filepath.Join(saveDir, fileName)) {
+			return nil
+		}
+		mutex.Lock()
+		saveDirListSet[saveDir] = voidMember
+		mutex.Unlock()
+		return filetools.WriteFile(filepath.Join(saveDir, fileName), specFileBytes, 0777)
+	}
+	// 下载一个账号中的所有文件,返回下载到的文件数量和成功处理的文件数量.
+	//  一个文件处理出错时继续处理其他文件,返回第一个错误
+	downloadAccount := func(children *jsontools.JsonParser) (int, int, error) {
+		var downloadErr error // 下载出错时,仍然处理已经下载下来的文件
+		var processErr error
+		var attemptNum, successNum int
+		handleFile := func(fileName string, zipBytes []byte) error {
+			attemptNum++
+			err := processZipBytes(fileName, zipBytes)
 			if err != nil {
-				return
-			}
-			// 数据交换文件最终存储的文件夹位置
-			saveDir = filepath.Join(receiveDir, string(receiverNameBytes))
-			if !filetools.IsPathExists(filepath.Join(saveDir, fileName)) {
-				saveDirListSet[saveDir] = voidMember
-				err = filetools.Rename(specFilePath, filepath.Join(saveDir, fileName))
-				if err != nil {
-					return
+				fmt.Println("无法处理下载的文件", fileName, err)
+				if processErr == nil {
+					processErr = err
 				}
+				return nil
 			}
-			err = filetools.RmDir(unzipFolderDir)
+			successNum++
+			return nil
+		}
+		ifssName := children.ReadJsonValue("/IFSSName").(string)
+		ifssDownloadDir := filepath.Join(receiveDir, ifssName)
+		ifssType := children.ReadJsonValue("/IFSSType").(string)
+		switch ifssType {
+		case "git":
+			g := newGitClient(children, ifssDownloadDir)
+			if g.InMemory { // 下载的文件直接交给接收流程处理,不写入磁盘
+				downloadErr = g.ReadNewFiles(receiveProgressChannel, handleFile)
+				if downloadErr == gittools.ErrNoContent {
+					return 0, 0, nil
+				}
+				if processErr != nil {
+					return attemptNum, successNum, processErr
+				}
+				return attemptNum, successNum, downloadErr
+			}
+			downloadErr = g.DownloadFromRepository(receiveProgressChannel)
+			if downloadErr == gittools.ErrNoContent {
+				return 0, 0, nil
+			}
+		case "webdav":
+			w := newWebdavClient(children, ifssDownloadDir)
+			downloadErr = w.DownloadAllFilesToFolder(receiveProgressChannel)
+		default:
+			panic("IFSS类型错误")
+		}
+		if !filetools.IsPathExists(ifssDownloadDir) { // 没有下载到任何文件
+			return 0, 0, downloadErr
+		}
+		filePathList, fileNameList, err := filetools.GenerateUnhiddenFilePathNameListFromFolder(ifssDownloadDir)
+		if err != nil {
+			return 0, 0, err
+		}
+		for i, filePath := range filePathList {
+			zipBytes, err := filetools.ReadFile(filePath)
 			if err != nil {
-				return
+				return attemptNum, successNum, err
 			}
+			handleFile(fileNameList[i], zipBytes)
 		}
+		if processErr != nil {
+			return attemptNum, successNum, processErr
+		}
+		return attemptNum, successNum, downloadErr
 	}
-	ownAccountParserList := ownAccountListJsonParser.GetAllChildren("OwnAccountList")
-	var wg sync.WaitGroup
-	wg.Add(len(ownAccountParserList))
-	for _, children := range ownAccountParserList {
-		go downloadGoroutine(&wg, children)
-	}
-	wg.Wait()
+	ownAccountParserList := excludeEmailAccountList(ownAccountListJsonParser.GetAllChildren("OwnAccountList"))
+	resultList := newAccountResultList(ownAccountParserList)
+	// 每个账号的错误记录在各自的结果中,一个账号出错时其他账号继续下载
+	workerpool.Run(0, len(ownAccountParserList), func(i int) error {
+		resultList[i].AttemptNum, resultList[i].SuccessNum, resultList[i].Err = downloadAccount(ownAccountParserList[i])
+		return nil
+	})
 	for key := range saveDirListSet {
 		saveDirList = append(saveDirList, key) // 利用set去重
 	}
+	sendAccountResultList(MSG_DOWNLOAD_RESULT, resultList, receiveProgressChannel)
+	var failedNum int
+	for _, result := range resultList {
+		if result.Err != nil {
+			failedNum++
+		}
+	}
+	if failedNum > 0 {
+		err = fmt.Errorf("%d个IFSS账号下载失败", failedNum)
+		fmt.Println(err)
+		return saveDirList, &MultiError{Err: err, ResultList: resultList}
+	}
 	return saveDirList, err
 }
 
@@ -207,28 +600,30 @@ func CleanIFSS(ownAccountListJsonParser *jsontools.JsonParser, receiveDir string
 		ifssName := children.ReadJsonValue("/IFSSName").(string)
 		ifssDownloadDir := filepath.Join(receiveDir, ifssName)
 		ifssType := children.ReadJsonValue("/IFSSType").(string)
-		ifssURL := children.ReadJsonValue("/IFSSURL").(string)
-		if !filetools.IsPathExists(ifssDownloadDir) { // 如果没有检测到下载下来了新内容
-			continue
-		}
 		// 删除在线记录
 		switch ifssType {
 		case "git":
-			ifssUserName := children.ReadJsonValue("/IFSSUserName").(string)
-			ifssPassword := children.ReadJsonValue("/IFSSUserPassword").(string)
-			g := gittools.NewGitClient(ifssURL, ifssDownloadDir, ifssUserName, ifssPassword)
+			g := newGitClient(children, ifssDownloadDir)
+			if !g.IsDownloaded() && !filetools.IsPathExists(ifssDownloadDir) { // 如果没有检测到下载下来了新内容
+				continue
+			}
 			err = g.CleanRepository()
 			if err != nil {
 				return err
 			}
 		case "webdav":
-			ifssUserName := children.ReadJsonValue("/IFSSUserName").(string)
-			ifssPassword := children.ReadJsonValue("/IFSSUserPassword").(string)
-			w := webdavtools.NewWebdavClient(ifssURL, ifssDownloadDir, ifssUserName, ifssPassword)
+			isDownloaded := filetools.IsPathExists(ifssDownloadDir) // 新建连接时会创建本地文件夹,需要先检查
+			w := newWebdavClient(children, ifssDownloadDir)
+			if !w.IsUploaded() && !isDownloaded { // 如果没有检测到下载下来了新内容,也没有上传过文件
+				filetools.RmDir(ifssDownloadDir)
+				continue
+			}
 			err = w.CleanWebdav()
 			if err != nil {
 				return err
 			}
+		case "email": // 邮箱账号由EmailReceiver接收
+			continue
 		default:
 			panic("IFSS类型错误")
 		}
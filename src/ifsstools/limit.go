@@ -0,0 +1,202 @@
+package ifsstools
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"xindauserbackground/src/crypto/envelope"
+	"xindauserbackground/src/jsontools"
+	"xindauserbackground/src/specfile"
+	"xindauserbackground/src/ziptools"
+)
+
+// IFSS账号的大小限制,在OwnAccountList中配置,为0时不限制
+type accountLimit struct {
+	MaxFileSize       int64 // 单个文件的最大大小(例如git托管平台对大文件的限制/邮件附件的大小限制)
+	MaxTotalSize      int64 // 一次上传到这个账号的所有文件的最大总大小(例如Webdav的配额)
+	MaxFileNumPerPush int   // 每次push最多包含的文件数量
+}
+
+// 读取账号的大小限制
+func readAccountLimit(accountParser *jsontools.JsonParser) accountLimit {
+	return accountLimit{
+		MaxFileSize:       int64(readIntValue(accountParser, "/MaxFileSize", 0)),
+		MaxTotalSize:      int64(readIntValue(accountParser, "/MaxTotalSize", 0)),
+		MaxFileNumPerPush: readIntValue(accountParser, "/MaxFileNumPerPush", 0),
+	}
+}
+
+// 是否配置了限制
+func (l accountLimit) isLimited() bool {
+	return l.MaxFileSize > 0 || l.MaxTotalSize > 0 || l.MaxFileNumPerPush > 0
+}
+
+// 打包好的文件超出了账号的大小限制.
+//  账号本身是可用的,故障转移时只需要把文件重新分配给其他账号,不把它标记为不可用
+type limitError struct {
+	err error
+}
+
+func (e *limitError) Error() string {
+	return e.err.Error()
+}
+
+// 判断上传的错误是不是超出了账号的大小限制
+func isLimitError(err error) bool {
+	_, ok := err.(*limitError)
+	return ok
+}
+
+// 已经分配了usedSize字节之后,是否还能放下大小为fileSize的文件
+func (l accountLimit) canHold(fileSize, usedSize int64) bool {
+	if l.MaxFileSize > 0 && fileSize > l.MaxFileSize {
+		return false
+	}
+	if l.MaxTotalSize > 0 && usedSize+fileSize > l.MaxTotalSize {
+		return false
+	}
+	return true
+}
+
+// 在push之前检查打包好的文件是否超出账号的大小限制
+func checkAccountLimit(accountParser *jsontools.JsonParser, zipFilePathList []string) error {
+	var err error
+	limit := readAccountLimit(accountParser)
+	var totalSize int64
+	for _, zipFilePath := range zipFilePathList {
+		fileInfo, err := os.Stat(zipFilePath)
+		if err != nil {
+			return err
+		}
+		if limit.MaxFileSize > 0 && fileInfo.Size() > limit.MaxFileSize {
+			err = fmt.Errorf("文件%s的大小%d超过了账号%s的单个文件大小限制%d", filepath.Base(zipFilePath), fileInfo.Size(), accountParser.ReadJsonValue("/IFSSName").(string), limit.MaxFileSize)
+			fmt.Println(err)
+			return &limitError{err}
+		}
+		totalSize += fileInfo.Size()
+	}
+	if limit.MaxTotalSize > 0 && totalSize > limit.MaxTotalSize {
+		err = fmt.Errorf("上传的总大小%d超过了账号%s的总大小限制%d", totalSize, accountParser.ReadJsonValue("/IFSSName").(string), limit.MaxTotalSize)
+		fmt.Println(err)
+		return &limitError{err}
+	}
+	return err
+}
+
+// 估计每个数据交换文件和路由信封打包后的大小
+func estimateUploadSizeMap(filePathList []string, envelopeMaxSize int) (map[string]int64, error) {
+	filePath_UploadSizeMap := make(map[string]int64)
+	for _, filePath := range filePathList {
+		fileInfo, err := os.Stat(filePath)
+		if err != nil {
+			fmt.Println("无法读取数据交换文件的大小", err)
+			return nil, err
+		}
+		filePath_UploadSizeMap[filePath] = int64(ziptools.EstimateMaxZipSize(int(fileInfo.Size()), envelopeMaxSize))
+	}
+	return filePath_UploadSizeMap, nil
+}
+
+// 检查fileNum个大小为uploadSize的文件(每个文件replicationFactor份)能否放进这些账号,不能时返回原因
+func checkFitAccountList(accountParserList []*jsontools.JsonParser, fileNum int, uploadSize int64, replicationFactor int) error {
+	var err error
+	var capacity, holdAccountNum int
+	for _, accountParser := range accountParserList {
+		limit := readAccountLimit(accountParser)
+		if !limit.canHold(uploadSize, 0) {
+			continue
+		}
+		holdAccountNum++
+		if limit.MaxTotalSize == 0 {
+			capacity += fileNum * replicationFactor
+		} else {
+			capacity += int(limit.MaxTotalSize / uploadSize)
+		}
+	}
+	if holdAccountNum == 0 {
+		err = fmt.Errorf("每个数据交换文件打包后约%d字节,超过了所有IFSS账号的单个文件大小限制", uploadSize)
+		return err
+	}
+	if holdAccountNum < replicationFactor {
+		err = fmt.Errorf("只有%d个IFSS账号能放下约%d字节的数据交换文件,少于复制因子%d", holdAccountNum, uploadSize, replicationFactor)
+		return err
+	}
+	if capacity < fileNum*replicationFactor {
+		err = fmt.Errorf("所有IFSS账号的总大小限制只能容纳%d个约%d字节的文件,而需要上传%d个", capacity, uploadSize, fileNum*replicationFactor)
+		return err
+	}
+	return err
+}
+
+// 根据文件大小和各个IFSS账号的大小限制,为发送阶段配置选择合适的分片数量和组数.
+//  优先使用配置中原有的DivideMethod和GroupNum,放不下时在其他可选的组合中选择冗余最多的一个;
+//  因为每个分片的长度都等于原文件的长度,单个文件的大小限制无法通过改变分片数量来满足,这种情况下返回原因
+func PlanSendStrategy(sendStrategyBytes []byte, neighborJsonParser *jsontools.JsonParser) ([]byte, error) {
+	var err error
+	sendStrategyJsonParser, err := jsontools.ReadJsonBytes(sendStrategyBytes)
+	if err != nil {
+		return nil, err
+	}
+	fileInfo, err := os.Stat(sendStrategyJsonParser.ReadJsonValue("/SrcFilePath").(string))
+	if err != nil {
+		fmt.Println("无法读取待发送文件的大小", err)
+		return nil, err
+	}
+	specFileLength, err := specfile.EstimateMaxSpecFileLength(sendStrategyJsonParser, int(fileInfo.Size()))
+	if err != nil {
+		return nil, err
+	}
+	hopList, err := readHopList(neighborJsonParser)
+	if err != nil {
+		return nil, err
+	}
+	// 与UploadToIFSS相同,路由信封中封装的是所有接收方的代号
+	receiverName := strings.Join(jsontools.ReadReceiverNameList(sendStrategyJsonParser), jsontools.ReceiverNameSeparator)
+	envelopeMaxSize := envelope.EstimateMaxSize(receiverName, hopList, envelope.DefaultMaxPaddingSize)
+	uploadSize := int64(ziptools.EstimateMaxZipSize(specFileLength, envelopeMaxSize))
+	replicationFactor := readIntValue(sendStrategyJsonParser, "/ReplicationFactor", 1)
+	accountParserList := neighborJsonParser.GetAllChildren("OwnAccountList")
+	// 可选的分片数量和组数,组数不能让某个组为空
+	type divideStrategy struct{ divideMethod, groupNum int }
+	requested := divideStrategy{readIntValue(sendStrategyJsonParser, "/DivideMethod", 0), readIntValue(sendStrategyJsonParser, "/GroupNum", 0)}
+	candidateList := []divideStrategy{requested}
+	var otherCandidateList []divideStrategy
+	for _, divideMethod := range []int{2, 4, 8} {
+		for groupNum := 1; groupNum <= divideMethod; groupNum++ {
+			maxNumInAGroup := (divideMethod + groupNum - 1) / groupNum
+			if (groupNum-1)*maxNumInAGroup >= divideMethod || (divideStrategy{divideMethod, groupNum}) == requested {
+				continue
+			}
+			otherCandidateList = append(otherCandidateList, divideStrategy{divideMethod, groupNum})
+		}
+	}
+	// 冗余分片占比越高,能够容忍丢失的文件越多
+	sort.SliceStable(otherCandidateList, func(i, j int) bool {
+		a, b := otherCandidateList[i], otherCandidateList[j]
+		return a.groupNum*(b.divideMethod+b.groupNum) > b.groupNum*(a.divideMethod+a.groupNum)
+	})
+	candidateList = append(candidateList, otherCandidateList...)
+	var fewestFileErr error // 文件数量最少的组合放不下的原因
+	fewestFileNum := -1
+	for _, candidate := range candidateList {
+		fileNum := candidate.divideMethod + candidate.groupNum
+		fitErr := checkFitAccountList(accountParserList, fileNum, uploadSize, replicationFactor)
+		if fitErr == nil {
+			if candidate != requested {
+				fmt.Println("原有的分片方式无法满足IFSS账号的大小限制,改为分成", candidate.divideMethod, "片", candidate.groupNum, "组")
+			}
+			sendStrategyJsonParser.SetValue(candidate.divideMethod, "DivideMethod")
+			sendStrategyJsonParser.SetValue(candidate.groupNum, "GroupNum")
+			return sendStrategyJsonParser.GenerateJsonBytes(), nil
+		}
+		if fewestFileNum < 0 || fileNum < fewestFileNum {
+			fewestFileNum = fileNum
+			fewestFileErr = fitErr
+		}
+	}
+	err = fmt.Errorf("无法找到能满足IFSS账号大小限制的分片方式: %v", fewestFileErr)
+	fmt.Println(err)
+	return nil, err
+}
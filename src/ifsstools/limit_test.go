@@ -0,0 +1,106 @@
+package ifsstools
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestAccountLimitCanHold(t *testing.T) {
+	testCaseList := []struct {
+		name        string
+		accountJson string
+		fileSize    int64
+		usedSize    int64
+		wantLimited bool
+		want        bool
+	}{
+		{"没有限制", `{}`, 1 << 30, 1 << 30, false, true},
+		{"单个文件未超出", `{"MaxFileSize": 100}`, 100, 1000, true, true},
+		{"单个文件超出", `{"MaxFileSize": 100}`, 101, 0, true, false},
+		{"总大小未超出", `{"MaxTotalSize": 500}`, 200, 300, true, true},
+		{"总大小超出", `{"MaxTotalSize": 500}`, 200, 301, true, false},
+		{"只限制文件数量", `{"MaxFileNumPerPush": 3}`, 1 << 30, 0, true, true},
+	}
+	for _, testCase := range testCaseList {
+		t.Run(testCase.name, func(t *testing.T) {
+			accountParser := newTestAccountParserList(t, []string{testCase.accountJson})[0]
+			limit := readAccountLimit(accountParser)
+			if limit.isLimited() != testCase.wantLimited {
+				t.Errorf("isLimited为%v,应为%v", limit.isLimited(), testCase.wantLimited)
+			}
+			if got := limit.canHold(testCase.fileSize, testCase.usedSize); got != testCase.want {
+				t.Errorf("canHold(%d, %d)为%v,应为%v", testCase.fileSize, testCase.usedSize, got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestCheckAccountLimit(t *testing.T) {
+	testCaseList := []struct {
+		name         string
+		accountJson  string
+		fileSizeList []int
+		wantErr      bool
+	}{
+		{"没有限制", `{}`, []int{1000, 2000}, false},
+		{"单个文件未超出", `{"MaxFileSize": 1000}`, []int{1000, 500}, false},
+		{"单个文件超出", `{"MaxFileSize": 1000}`, []int{500, 1001}, true},
+		{"总大小未超出", `{"MaxTotalSize": 1500}`, []int{1000, 500}, false},
+		{"总大小超出", `{"MaxTotalSize": 1500}`, []int{1000, 501}, true},
+	}
+	for _, testCase := range testCaseList {
+		t.Run(testCase.name, func(t *testing.T) {
+			accountParser := newTestAccountParserList(t, []string{testCase.accountJson})[0]
+			dir := t.TempDir()
+			var zipFilePathList []string
+			for i, fileSize := range testCase.fileSizeList {
+				zipFilePath := filepath.Join(dir, fmt.Sprint(i, ".zip"))
+				if err := ioutil.WriteFile(zipFilePath, make([]byte, fileSize), 0644); err != nil {
+					t.Fatal(err)
+				}
+				zipFilePathList = append(zipFilePathList, zipFilePath)
+			}
+			err := checkAccountLimit(accountParser, zipFilePathList)
+			if testCase.wantErr {
+				if !isLimitError(err) {
+					t.Errorf("应当返回超出大小限制的错误,实际为%v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("返回了错误: %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckFitAccountList(t *testing.T) {
+	testCaseList := []struct {
+		name              string
+		accountJsonList   []string
+		fileNum           int
+		uploadSize        int64
+		replicationFactor int
+		wantErr           bool
+	}{
+		{"没有限制", []string{`{}`}, 12, 1000, 1, false},
+		{"总大小恰好放下", []string{`{"MaxTotalSize": 3000}`, `{"MaxTotalSize": 2000}`}, 5, 1000, 1, false},
+		{"总大小放不下", []string{`{"MaxTotalSize": 3000}`, `{"MaxTotalSize": 1999}`}, 5, 1000, 1, true},
+		{"所有账号的单个文件限制都超出", []string{`{"MaxFileSize": 999}`, `{"MaxFileSize": 500}`}, 1, 1000, 1, true},
+		{"跳过放不下单个文件的账号", []string{`{"MaxFileSize": 999}`, `{}`}, 6, 1000, 1, false},
+		{"能放下文件的账号少于复制因子", []string{`{"MaxFileSize": 999}`, `{}`}, 1, 1000, 2, true},
+		{"复制后总大小放不下", []string{`{"MaxTotalSize": 3000}`, `{"MaxTotalSize": 3000}`}, 4, 1000, 2, true},
+		{"复制后总大小放得下", []string{`{"MaxTotalSize": 4000}`, `{"MaxTotalSize": 4000}`}, 4, 1000, 2, false},
+	}
+	for _, testCase := range testCaseList {
+		t.Run(testCase.name, func(t *testing.T) {
+			accountParserList := newTestAccountParserList(t, testCase.accountJsonList)
+			err := checkFitAccountList(accountParserList, testCase.fileNum, testCase.uploadSize, testCase.replicationFactor)
+			if (err != nil) != testCase.wantErr {
+				t.Errorf("返回的错误为%v,应当返回错误: %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package ifsstools
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"xindauserbackground/src/filetools"
+	"xindauserbackground/src/jsontools"
+)
+
+// 转发记录的保存时间,超过这个时间的记录会被清除
+const RelayRecordLifetime = 30 * 24 * time.Hour
+
+// 转发文件夹中记录数据交换文件剩余转发次数的文件后缀
+const HopLimitFileSuffix = "_hop"
+
+// 记录数据交换文件剩余的转发次数,即本节点打开的那一层信封中的HopLimit
+func writeHopLimit(specFilePath string, hopLimit int) error {
+	return filetools.WriteFile(specFilePath+HopLimitFileSuffix, []byte(strconv.Itoa(hopLimit)), 0777)
+}
+
+// 读取数据交换文件剩余的转发次数,没有记录或者记录不合法时视为0
+func readHopLimit(specFilePath string) int {
+	hopLimitBytes, err := filetools.ReadFile(specFilePath + HopLimitFileSuffix)
+	if err != nil {
+		return 0
+	}
+	hopLimit, err := strconv.Atoi(strings.TrimSpace(string(hopLimitBytes)))
+	if err != nil {
+		return 0
+	}
+	return hopLimit
+}
+
+// 读取已经转发过的数据交换文件的记录,返回文件内容哈希对应转发时间的Map
+func readRelayRecord(relayRecordPath string) (map[string]int64, error) {
+	hash_TimeMap := make(map[string]int64)
+	if !filetools.IsPathExists(relayRecordPath) {
+		return hash_TimeMap, nil
+	}
+	relayRecordParser, err := jsontools.ReadJsonFile(relayRecordPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, children := range relayRecordParser.GetAllChildren("ForwardedList") {
+		hash_TimeMap[children.ReadJsonValue("/Hash").(string)] = int64(children.ReadJsonValue("/Time").(float64))
+	}
+	return hash_TimeMap, nil
+}
+
+// 写入转发记录,并清除过期的记录
+func writeRelayRecord(relayRecordPath string, hash_TimeMap map[string]int64) error {
+	relayRecordParser := jsontools.GenerateNewJsonParser()
+	relayRecordParser.SetArray("ForwardedList")
+	expiredTime := time.Now().Add(-RelayRecordLifetime).Unix()
+	for hash, forwardTime := range hash_TimeMap {
+		if forwardTime < expiredTime {
+			continue
+		}
+		record := jsontools.GenerateNewJsonParser()
+		record.SetValue(hash, "Hash")
+		record.SetValue(forwardTime, "Time")
+		relayRecordParser.AppendArray(record.Parser.Data(), "ForwardedList")
+	}
+	return relayRecordParser.WriteJsonFile(relayRecordPath)
+}
+
+// 删除转发文件夹中的数据交换文件,以及它的路由信封和转发次数记录
+func removeForwardFile(specFilePath string) {
+	filetools.RmFile(specFilePath)
+	filetools.RmFile(specFilePath + "_")
+	filetools.RmFile(specFilePath + HopLimitFileSuffix)
+}
+
+// 在邻居列表中找到下一跳节点,找不到则返回nil
+func findNeighbor(neighborListJsonParser *jsontools.JsonParser, neighborName string) *jsontools.JsonParser {
+	for _, children := range neighborListJsonParser.GetAllChildren("NeighborList") {
+		if children.IsPathExists("/Name") && children.ReadJsonValue("/Name").(string) == neighborName {
+			return children
+		}
+	}
+	return nil
+}
+
+// 中继转发:把下载时放到转发文件夹中的数据交换文件,连同剩下的路由信封一起上传到下一跳节点的IFSS账号.
+//  每次转发消耗一次信封中的转发次数,用完时丢弃;同一个数据交换文件只转发一次,下一跳是本节点自己时直接丢弃,以防止环路
+func RelayToNeighbors(receiveDir, selfName string, neighborListJsonParser *jsontools.JsonParser, relayRecordPath string, sendProgressChannel chan []byte) error {
+	var err error
+	forwardFolderDir := filepath.Join(receiveDir, ForwardFolderName)
+	if !filetools.IsPathExists(forwardFolderDir) {
+		return err
+	}
+	hash_TimeMap, err := readRelayRecord(relayRecordPath)
+	if err != nil {
+		return err
+	}
+	nextHopDirList, nextHopNameList, err := filetools.GenerateUnhiddenFolderDirNameListFromFolder(forwardFolderDir)
+	if err != nil {
+		return err
+	}
+	for i, nextHopDir := range nextHopDirList {
+		nextHopName := nextHopNameList[i]
+		if nextHopName == selfName {
+			fmt.Println("下一跳节点是本节点自己,丢弃这些数据交换文件以防止环路")
+			filetools.RmDir(nextHopDir)
+			continue
+		}
+		neighborJsonParser := findNeighbor(neighborListJsonParser, nextHopName)
+		if neighborJsonParser == nil {
+			fmt.Println("无法在邻居列表中找到下一跳节点", nextHopName)
+			continue
+		}
+		filePathList, fileNameList, err := filetools.GenerateUnhiddenFilePathNameListFromFolder(nextHopDir)
+		if err != nil {
+			return err
+		}
+		var relayFilePathList, relayHashList []string
+		var envelopeMaxSize int
+		for j, filePath := range filePathList {
+			if strings.HasSuffix(fileNameList[j], "_") || strings.HasSuffix(fileNameList[j], HopLimitFileSuffix) { // 路由信封和转发次数
+				continue
+			}
+			hopLimit := readHopLimit(filePath) - 1 // 本次转发消耗一次
+			if hopLimit < 0 {
+				fmt.Println("数据交换文件", fileNameList[j], "的转发次数已经用完,丢弃")
+				removeForwardFile(filePath)
+				continue
+			}
+			hash, err := filetools.GetFileHash(filePath)
+			if err != nil {
+				return err
+			}
+			if _, isExist := hash_TimeMap[hash]; isExist {
+				fmt.Println("数据交换文件", fileNameList[j], "已经转发过,丢弃以防止环路")
+				removeForwardFile(filePath)
+				continue
+			}
+			envelopeFileInfo, err := os.Stat(filePath + "_")
+			if err != nil {
+				return err
+			}
+			if int(envelopeFileInfo.Size()) > envelopeMaxSize {
+				envelopeMaxSize = int(envelopeFileInfo.Size())
+			}
+			relayFilePathList = append(relayFilePathList, filePath)
+			relayHashList = append(relayHashList, hash)
+		}
+		if len(relayFilePathList) == 0 {
+			filetools.RmDir(nextHopDir)
+			continue
+		}
+		// 路由信封在发送方已经为下一跳加密好了,直接使用
+		readEnvelope := func(filePath string) ([]byte, error) {
+			return filetools.ReadFile(filePath + "_")
+		}
+		err = uploadToAccountList(nextHopDir, relayFilePathList, neighborJsonParser.GetAllChildren("OwnAccountList"), readEnvelope, uploadOption{envelopeMaxSize: envelopeMaxSize}, sendProgressChannel)
+		if err != nil {
+			return err
+		}
+		for _, hash := range relayHashList {
+			hash_TimeMap[hash] = time.Now().Unix()
+		}
+		err = writeRelayRecord(relayRecordPath, hash_TimeMap)
+		if err != nil {
+			return err
+		}
+		fmt.Println("已经将", len(relayFilePathList), "个数据交换文件转发给", nextHopName)
+		err = filetools.RmDir(nextHopDir)
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
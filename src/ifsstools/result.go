@@ -0,0 +1,98 @@
+package ifsstools
+
+import (
+	"fmt"
+	"strings"
+	"xindauserbackground/src/jsontools"
+)
+
+// 反馈给前端的账号结果的消息类型
+const (
+	MSG_UPLOAD_RESULT   = "uploadResult"
+	MSG_DOWNLOAD_RESULT = "downloadResult"
+)
+
+// 一个IFSS账号的上传/下载结果
+type AccountResult struct {
+	IFSSName   string
+	AttemptNum int   // 尝试上传/下载的文件数量
+	SuccessNum int   // 成功上传/下载的文件数量
+	Err        error // 为nil表示这个账号没有出错
+}
+
+// 上传/下载失败时返回给调用方的错误,包含整体的错误原因和每个账号的结果,
+//  调用方可以使用errors.As取出
+type MultiError struct {
+	Err        error // 整体的错误原因
+	ResultList []AccountResult
+}
+
+func (m *MultiError) Error() string {
+	var failedList []string
+	for _, result := range m.ResultList {
+		if result.Err != nil {
+			failedList = append(failedList, fmt.Sprintf("%s(成功%d/%d: %v)", result.IFSSName, result.SuccessNum, result.AttemptNum, result.Err))
+		}
+	}
+	if len(failedList) == 0 {
+		return m.Err.Error()
+	}
+	return fmt.Sprintf("%v, 出错的IFSS账号: %s", m.Err, strings.Join(failedList, "; "))
+}
+
+func (m *MultiError) Unwrap() error {
+	return m.Err
+}
+
+// 为每个账号生成空的结果
+func newAccountResultList(accountParserList []*jsontools.JsonParser) []AccountResult {
+	resultList := make([]AccountResult, len(accountParserList))
+	for i, accountParser := range accountParserList {
+		resultList[i].IFSSName = accountParser.ReadJsonValue("/IFSSName").(string)
+	}
+	return resultList
+}
+
+// 把每个有文件的账号的结果反馈到进度channel
+func sendAccountResultList(msgType string, resultList []AccountResult, progressChannel chan []byte) {
+	for _, result := range resultList {
+		if result.AttemptNum == 0 && result.Err == nil {
+			continue
+		}
+		var errString string
+		if result.Err != nil {
+			errString = result.Err.Error()
+		}
+		progressChannel <- jsontools.GenerateAccountResultJsonBytes(msgType, result.IFSSName, result.AttemptNum, result.SuccessNum, errString)
+	}
+}
+
+// 统计转发给sendProgressChannel的上传进度中成功上传的文件数量
+type progressCounter struct {
+	channel chan []byte
+	done    chan struct{}
+	sendNum int
+}
+
+// 生成一个新的统计,上传时使用它的channel代替sendProgressChannel
+func newProgressCounter(sendProgressChannel chan []byte) *progressCounter {
+	c := &progressCounter{channel: make(chan []byte), done: make(chan struct{})}
+	go func() {
+		for progressBytes := range c.channel {
+			progressJsonParser, err := jsontools.ReadJsonBytes(progressBytes)
+			if err == nil && progressJsonParser.IsPathExists("/SendNum") {
+				c.sendNum += int(progressJsonParser.ReadJsonValue("/SendNum").(float64))
+			}
+			sendProgressChannel <- progressBytes
+		}
+		close(c.done)
+	}()
+	return c
+}
+
+// 结束统计,返回成功上传的文件数量
+func (c *progressCounter) stop() int {
+	close(c.channel)
+	<-c.done
+	return c.sendNum
+}
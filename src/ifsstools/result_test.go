@@ -0,0 +1,119 @@
+package ifsstools
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"xindauserbackground/src/jsontools"
+)
+
+func TestMultiError(t *testing.T) {
+	errCause := errors.New("部分文件上传失败")
+	testCaseList := []struct {
+		name            string
+		resultList      []AccountResult
+		wantContainList []string
+		wantNotContain  string
+	}{
+		{
+			name:            "没有账号出错",
+			resultList:      []AccountResult{{IFSSName: "git1", AttemptNum: 2, SuccessNum: 2}},
+			wantContainList: []string{"部分文件上传失败"},
+			wantNotContain:  "出错的IFSS账号",
+		},
+		{
+			name: "一个账号出错",
+			resultList: []AccountResult{
+				{IFSSName: "git1", AttemptNum: 2, SuccessNum: 2},
+				{IFSSName: "dav1", AttemptNum: 3, SuccessNum: 1, Err: errors.New("连接超时")},
+			},
+			wantContainList: []string{"部分文件上传失败", "dav1(成功1/3: 连接超时)"},
+			wantNotContain:  "git1",
+		},
+		{
+			name: "多个账号出错",
+			resultList: []AccountResult{
+				{IFSSName: "git1", AttemptNum: 2, Err: errors.New("认证失败")},
+				{IFSSName: "mail1", AttemptNum: 1, Err: fmt.Errorf("附件过大")},
+			},
+			wantContainList: []string{"git1(成功0/2: 认证失败)", "mail1(成功0/1: 附件过大)", "; "},
+		},
+	}
+	for _, testCase := range testCaseList {
+		t.Run(testCase.name, func(t *testing.T) {
+			var err error = &MultiError{Err: errCause, ResultList: testCase.resultList}
+			wrappedErr := fmt.Errorf("发送失败: %w", err)
+			var multiError *MultiError
+			if !errors.As(wrappedErr, &multiError) {
+				t.Fatalf("无法用errors.As取出MultiError")
+			}
+			if len(multiError.ResultList) != len(testCase.resultList) {
+				t.Errorf("取出的结果有%d个,应为%d个", len(multiError.ResultList), len(testCase.resultList))
+			}
+			if !errors.Is(wrappedErr, errCause) {
+				t.Errorf("errors.Is无法找到整体的错误原因")
+			}
+			for _, wantContain := range testCase.wantContainList {
+				if !strings.Contains(err.Error(), wantContain) {
+					t.Errorf("错误信息%q中没有%q", err.Error(), wantContain)
+				}
+			}
+			if testCase.wantNotContain != "" && strings.Contains(err.Error(), testCase.wantNotContain) {
+				t.Errorf("错误信息%q中不应有%q", err.Error(), testCase.wantNotContain)
+			}
+		})
+	}
+}
+
+func TestSendAccountResultList(t *testing.T) {
+	resultList := []AccountResult{
+		{IFSSName: "git1", AttemptNum: 2, SuccessNum: 2},
+		{IFSSName: "idle"}, // 没有文件的账号不反馈
+		{IFSSName: "dav1", AttemptNum: 1, Err: errors.New("连接超时")},
+		{IFSSName: "down", Err: errors.New("账号不可用")},
+	}
+	progressChannel := make(chan []byte, len(resultList))
+	sendAccountResultList(MSG_UPLOAD_RESULT, resultList, progressChannel)
+	close(progressChannel)
+	testCaseList := []struct {
+		ifssName   string
+		attemptNum int
+		successNum int
+		errString  string
+	}{
+		{"git1", 2, 2, ""},
+		{"dav1", 1, 0, "连接超时"},
+		{"down", 0, 0, "账号不可用"},
+	}
+	for _, testCase := range testCaseList {
+		t.Run(testCase.ifssName, func(t *testing.T) {
+			progressBytes, isOk := <-progressChannel
+			if !isOk {
+				t.Fatalf("没有收到账号%s的结果", testCase.ifssName)
+			}
+			progressJsonParser, err := jsontools.ReadJsonBytes(progressBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msgType := progressJsonParser.ReadJsonValue("/MsgType").(string); msgType != MSG_UPLOAD_RESULT {
+				t.Errorf("消息类型为%s,应为%s", msgType, MSG_UPLOAD_RESULT)
+			}
+			if ifssName := progressJsonParser.ReadJsonValue("/IFSSName").(string); ifssName != testCase.ifssName {
+				t.Fatalf("账号为%s,应为%s", ifssName, testCase.ifssName)
+			}
+			if attemptNum := int(progressJsonParser.ReadJsonValue("/AttemptNum").(float64)); attemptNum != testCase.attemptNum {
+				t.Errorf("尝试的文件数量为%d,应为%d", attemptNum, testCase.attemptNum)
+			}
+			if successNum := int(progressJsonParser.ReadJsonValue("/SuccessNum").(float64)); successNum != testCase.successNum {
+				t.Errorf("成功的文件数量为%d,应为%d", successNum, testCase.successNum)
+			}
+			if errString := progressJsonParser.ReadJsonValue("/Error").(string); errString != testCase.errString {
+				t.Errorf("错误为%q,应为%q", errString, testCase.errString)
+			}
+		})
+	}
+	if _, isOk := <-progressChannel; isOk {
+		t.Errorf("反馈了多余的结果")
+	}
+}
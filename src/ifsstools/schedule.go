@@ -0,0 +1,76 @@
+package ifsstools
+
+import (
+	"sort"
+	"time"
+	"xindauserbackground/src/jsontools"
+)
+
+// 读取上传的时间窗口(秒),没有配置时为0,即立即上传.
+//  时间窗口不能超过发送方能接受的最长等待时间Timer
+func readUploadWindow(sendStrategyJsonParser *jsontools.JsonParser) time.Duration {
+	if sendStrategyJsonParser == nil {
+		return 0
+	}
+	uploadWindow := readDurationSecond(sendStrategyJsonParser, "/UploadWindow", 0)
+	timer := readDurationSecond(sendStrategyJsonParser, "/Timer", 0)
+	if timer > 0 && uploadWindow > timer {
+		uploadWindow = timer
+	}
+	return uploadWindow
+}
+
+// 为一个账号中的每个文件生成随机的上传时刻(相对于开始上传的时刻).
+//  账号先随机延迟[0, uploadWindow/2],之后每个文件在剩余的时间窗口内随机分布
+func generateUploadOffsetList(fileNum int, uploadWindow time.Duration) []time.Duration {
+	windowSecond := int(uploadWindow / time.Second)
+	accountDelaySecond := randomIntInRange(0, windowSecond/2)
+	var offsetList []time.Duration
+	for i := 0; i < fileNum; i++ {
+		offsetList = append(offsetList, time.Duration(randomIntInRange(accountDelaySecond, windowSecond))*time.Second)
+	}
+	sort.Slice(offsetList, func(i, j int) bool { return offsetList[i] < offsetList[j] })
+	return offsetList
+}
+
+// 在时间窗口内按照随机的时刻逐个上传文件夹中的文件,每个文件单独push或上传
+func uploadWithJitter(children *jsontools.JsonParser, ifssFolderDir string, fileNameList []string, uploadWindow time.Duration, sendProgressChannel chan []byte) error {
+	var err error
+	startTime := time.Now()
+	offsetList := generateUploadOffsetList(len(fileNameList), uploadWindow)
+	waitUntil := func(offset time.Duration) {
+		time.Sleep(time.Until(startTime.Add(offset)))
+	}
+	ifssType := children.ReadJsonValue("/IFSSType").(string)
+	switch ifssType {
+	case "git":
+		g := newGitClient(children, ifssFolderDir)
+		err = g.CloneRepository()
+		if err != nil {
+			return err
+		}
+		for i, fileName := range fileNameList {
+			waitUntil(offsetList[i])
+			err = g.PushFileToRepository(fileName, sendProgressChannel)
+			if err != nil {
+				return err
+			}
+		}
+	case "webdav":
+		w := newWebdavClient(children, ifssFolderDir)
+		err = w.MakeWebdavDir()
+		if err != nil {
+			return err
+		}
+		for i, fileName := range fileNameList {
+			waitUntil(offsetList[i])
+			err = w.UploadFileFromFolder(fileName, sendProgressChannel)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		panic("IFSS类型错误")
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package webdavtools
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"xindauserbackground/src/filetools"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+// 分块上传的默认配置
+const (
+	DefaultChunkSize    = 10 * 1024 * 1024 // 配置了分块上传地址但没有配置块大小时使用的块大小
+	ChunkUploadRetryNum = 3                // 分块上传中断后,重新上传未完成的块的次数
+	ChunkDirLifetime    = 24 * time.Hour   // 超过这个时间没有完成的分块上传临时文件夹会被删除
+)
+
+// 分块上传临时文件夹的前缀
+const ChunkDirPrefix = TransferDirPrefix + "chunk-"
+
+// 文件是否需要分块上传
+func (w Webdav) isChunkUpload(size int64) bool {
+	return w.ChunkSize > 0 && w.ChunkUploadUrl != "" && size > w.ChunkSize
+}
+
+// 生成分块上传使用的客户端,根路径为服务器的根,这样合并时MOVE的目标可以指向文件在Webdav中的地址.
+//  返回客户端、分块上传地址的路径和Webdav地址的路径
+func (w Webdav) newChunkClient() (*gowebdav.Client, string, string, error) {
+	chunkUrl, err := url.Parse(w.ChunkUploadUrl)
+	if err != nil {
+		return nil, "", "", err
+	}
+	fileUrl, err := url.Parse(w.Url)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if chunkUrl.Host != fileUrl.Host {
+		return nil, "", "", fmt.Errorf("分块上传地址%s和Webdav地址%s不在同一个服务器上", w.ChunkUploadUrl, w.Url)
+	}
+	client := gowebdav.NewClient(chunkUrl.Scheme+"://"+chunkUrl.Host+"/", w.UserName, w.Password)
+	return client, chunkUrl.Path, fileUrl.Path, nil
+}
+
+// 删除超过ChunkDirLifetime还没有完成的分块上传临时文件夹,正在进行的上传不受影响
+func removeStaleChunkDirs(client *gowebdav.Client, chunkRoot string) error {
+	chunkDirInfoList, err := client.ReadDir(chunkRoot)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, chunkDirInfo := range chunkDirInfoList {
+		if !chunkDirInfo.IsDir() || !strings.HasPrefix(chunkDirInfo.Name(), ChunkDirPrefix) {
+			continue
+		}
+		if chunkDirInfo.ModTime().IsZero() || time.Since(chunkDirInfo.ModTime()) < ChunkDirLifetime {
+			continue
+		}
+		err = client.RemoveAll(path.Join(chunkRoot, chunkDirInfo.Name()))
+		if err != nil {
+			return err
+		}
+		fmt.Println("已经删除过期的分块上传临时文件夹", chunkDirInfo.Name())
+	}
+	return nil
+}
+
+// Nextcloud风格的分块上传:在分块上传地址中新建一个临时文件夹,以偏移量命名上传每一块,
+//  最后把临时文件夹中的.file移动到文件在Webdav中的位置,由服务器合并.
+//  临时文件夹以账号、文件内容的哈希和大小命名,与上传的目标路径无关,程序重启后重新上传同一个文件时,
+//  已经上传完整的块会被跳过
+func (w Webdav) uploadFileInChunks(webdavDir string, file *os.File, size int64) error {
+	client, chunkRoot, fileRoot, err := w.newChunkClient()
+	if err != nil {
+		return err
+	}
+	err = removeStaleChunkDirs(client, chunkRoot)
+	if err != nil {
+		fmt.Println("无法删除过期的分块上传临时文件夹", err)
+	}
+	fileHash, err := filetools.GetFileHash(file.Name())
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256([]byte(fmt.Sprint(w.Url, "-", w.UserName, "-", fileHash, "-", size)))
+	chunkDir := path.Join(chunkRoot, ChunkDirPrefix+hex.EncodeToString(hash[:8]))
+	for i := 0; i < ChunkUploadRetryNum; i++ {
+		err = w.uploadChunks(client, chunkDir, file, size)
+		if err == nil {
+			break
+		}
+		fmt.Println("分块上传中断,重新上传未完成的块", err)
+	}
+	if err != nil {
+		return err
+	}
+	return client.Rename(path.Join(chunkDir, ".file"), path.Join(fileRoot, webdavDir), true)
+}
+
+// 上传临时文件夹中还没有的块
+func (w Webdav) uploadChunks(client *gowebdav.Client, chunkDir string, file *os.File, size int64) error {
+	uploadedChunkName_SizeMap := make(map[string]int64)
+	chunkInfoList, err := client.ReadDir(chunkDir)
+	if isNotFound(err) {
+		err = client.Mkdir(chunkDir, 0777)
+	}
+	if err != nil {
+		return err
+	}
+	for _, chunkInfo := range chunkInfoList {
+		uploadedChunkName_SizeMap[chunkInfo.Name()] = chunkInfo.Size()
+	}
+	for offset := int64(0); offset < size; offset += w.ChunkSize {
+		chunkSize := w.ChunkSize
+		if size-offset < chunkSize {
+			chunkSize = size - offset
+		}
+		chunkName := fmt.Sprintf("%015d", offset) // 服务器按照块名的顺序合并
+		if uploadedSizeOfChunk, isExist := uploadedChunkName_SizeMap[chunkName]; isExist && uploadedSizeOfChunk == chunkSize {
+			continue
+		}
+		chunkBytes := make([]byte, chunkSize)
+		_, err = file.ReadAt(chunkBytes, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		err = client.Write(path.Join(chunkDir, chunkName), chunkBytes, 0777)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package webdavtools
+
+import (
+	"fmt"
+	"path/filepath"
+	"xindauserbackground/src/filetools"
+	"xindauserbackground/src/ifsstools/webdavtools/utils"
+	"xindauserbackground/src/jsontools"
+)
+
+// 记录已经下载过的Webdav文件的索引,存放在本地文件夹中
+const IndexFileName = ".webdav_index.json"
+
+// 文件的版本标记,服务器提供ETag时使用ETag,否则使用修改时间和大小
+func getFileTag(fileStat *utils.FileStat) string {
+	if fileStat.ETag != "" {
+		return fileStat.ETag
+	}
+	return fmt.Sprint(fileStat.LastModified, "-", fileStat.Size)
+}
+
+// 读取已经下载过的文件的索引,返回文件在Webdav中的路径对应下载时的版本标记的Map
+func (w Webdav) readIndex() (map[string]string, error) {
+	path_TagMap := make(map[string]string)
+	indexPath := filepath.Join(w.LocalDir, IndexFileName)
+	if !filetools.IsPathExists(indexPath) {
+		return path_TagMap, nil
+	}
+	indexParser, err := jsontools.ReadJsonFile(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, children := range indexParser.GetAllChildren("EntryList") {
+		path_TagMap[children.ReadJsonValue("/Path").(string)] = children.ReadJsonValue("/Tag").(string)
+	}
+	return path_TagMap, nil
+}
+
+// 写入已经下载过的文件的索引
+func (w Webdav) writeIndex(path_TagMap map[string]string) error {
+	indexParser := jsontools.GenerateNewJsonParser()
+	indexParser.SetArray("EntryList")
+	for path, tag := range path_TagMap {
+		entry := jsontools.GenerateNewJsonParser()
+		entry.SetValue(path, "Path")
+		entry.SetValue(tag, "Tag")
+		indexParser.AppendArray(entry.Parser.Data(), "EntryList")
+	}
+	return indexParser.WriteJsonFile(filepath.Join(w.LocalDir, IndexFileName))
+}
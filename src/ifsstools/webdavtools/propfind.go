@@ -0,0 +1,133 @@
+package webdavtools
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+// 本地文件的内容哈希,用来和服务器提供的ETag或校验和比较
+type contentHash struct {
+	MD5    string
+	SHA1   string
+	SHA256 string
+}
+
+// 读取一遍文件,同时计算MD5/SHA1/SHA256
+func getContentHash(file *os.File) (contentHash, error) {
+	md5Hash, sha1Hash, sha256Hash := md5.New(), sha1.New(), sha256.New()
+	_, err := io.Copy(io.MultiWriter(md5Hash, sha1Hash, sha256Hash), io.NewSectionReader(file, 0, 1<<62))
+	if err != nil {
+		return contentHash{}, err
+	}
+	return contentHash{hex.EncodeToString(md5Hash.Sum(nil)), hex.EncodeToString(sha1Hash.Sum(nil)), hex.EncodeToString(sha256Hash.Sum(nil))}, nil
+}
+
+// 有些服务器直接用内容的MD5作为ETag,这时返回MD5,否则返回空
+func getContentMD5FromETag(etag string) string {
+	etag = strings.Trim(strings.TrimPrefix(etag, "W/"), "\"")
+	if len(etag) != md5.Size*2 {
+		return ""
+	}
+	if _, err := hex.DecodeString(etag); err != nil {
+		return ""
+	}
+	return strings.ToLower(etag)
+}
+
+// 用PROPFIND读取gowebdav不支持的属性,propXML是<d:prop>中的内容,结果解析到multistatus中.
+//  服务器不支持PROPFIND这些属性时返回false
+func (w Webdav) propfind(webdavPath, propXML string, multistatus interface{}) (bool, error) {
+	body := `<?xml version="1.0" encoding="UTF-8"?><d:propfind xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns"><d:prop>` + propXML + `</d:prop></d:propfind>`
+	req, err := http.NewRequest("PROPFIND", gowebdav.PathEscape(gowebdav.Join(w.Url, webdavPath)), strings.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.SetBasicAuth(w.UserName, w.Password)
+	req.Header.Set("Depth", "0")
+	req.Header.Set("Content-Type", "application/xml;charset=UTF-8")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 207 {
+		return false, nil
+	}
+	err = xml.NewDecoder(resp.Body).Decode(multistatus)
+	return err == nil, nil
+}
+
+// 用PROPFIND读取Nextcloud/ownCloud保存的文件校验和,格式为"SHA1:... MD5:... ADLER32:...",服务器不提供时返回空
+func (w Webdav) readChecksums(webdavPath string) (string, error) {
+	var multistatus struct {
+		ChecksumList []string `xml:"response>propstat>prop>checksums>checksum"`
+	}
+	_, err := w.propfind(webdavPath, "<oc:checksums/>", &multistatus)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(multistatus.ChecksumList, " "), nil
+}
+
+// 用PROPFIND读取RFC 4331的剩余空间,服务器不提供时返回-1
+func (w Webdav) readQuotaAvailableBytes(webdavPath string) (int64, error) {
+	var multistatus struct {
+		QuotaAvailableBytes string `xml:"response>propstat>prop>quota-available-bytes"`
+	}
+	_, err := w.propfind(webdavPath, "<d:quota-available-bytes/>", &multistatus)
+	if err != nil {
+		return -1, err
+	}
+	quotaAvailableBytes, err := strconv.ParseInt(strings.TrimSpace(multistatus.QuotaAvailableBytes), 10, 64)
+	if err != nil {
+		return -1, nil
+	}
+	return quotaAvailableBytes, nil
+}
+
+// 比较服务器的校验和与本地文件的哈希,返回是否有可以比较的算法,以及不一致时的错误
+func compareChecksums(checksums string, localHash contentHash) (bool, error) {
+	algorithm_HashMap := map[string]string{"MD5": localHash.MD5, "SHA1": localHash.SHA1, "SHA256": localHash.SHA256}
+	isCompared := false
+	for _, checksum := range strings.Fields(checksums) {
+		algorithmAndHash := strings.SplitN(checksum, ":", 2)
+		if len(algorithmAndHash) != 2 {
+			continue
+		}
+		localValue, isExist := algorithm_HashMap[strings.ToUpper(algorithmAndHash[0])]
+		if !isExist {
+			continue
+		}
+		if !strings.EqualFold(localValue, algorithmAndHash[1]) {
+			return true, fmt.Errorf("服务器的%s校验和%s与本地文件不一致", algorithmAndHash[0], algorithmAndHash[1])
+		}
+		isCompared = true
+	}
+	return isCompared, nil
+}
+
+// 服务器既没有内容ETag也没有校验和时,读回文件计算SHA256
+func (w Webdav) readBackHash(webdavPath string) (string, error) {
+	data, err := w.Client.ReadStream(webdavPath)
+	if err != nil {
+		return "", err
+	}
+	defer data.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, data)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -21,6 +21,8 @@ type FileStat struct {
 	MD5          string   `json:"md5"`
 	FileType     FileType `json:"fileType"`
 	LastModified int64    `json:"lastModified"`
+	Size         int64    `json:"size"`
+	ETag         string   `json:"etag"`
 	Version      int64
 }
 
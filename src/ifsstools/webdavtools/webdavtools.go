@@ -6,9 +6,12 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"xindauserbackground/src/filetools"
 	"xindauserbackground/src/jsontools"
 	"xindauserbackground/src/ifsstools/webdavtools/utils"
+	"xindauserbackground/src/workerpool"
 
 	"github.com/studio-b12/gowebdav"
 )
@@ -18,11 +21,29 @@ type Webdav struct {
 	UserName  string
 	Password  string
 	Url       string
-	WebdavDir string
+	WebdavDir string // 数据交换文件的存储文件夹,每个连接上传的文件放在其中一个随机命名的子文件夹里
 	LocalDir  string
+	WorkerNum int // 同时上传的文件数量,为0时使用默认的并发数量
 	Client    *gowebdav.Client
+
+	ChunkSize      int64  // 大于0并且配置了ChunkUploadUrl时,超过这个大小的文件分块上传
+	ChunkUploadUrl string // Nextcloud的分块上传地址,例如https://host/remote.php/dav/uploads/<用户名>/
+
+	transferDirName string // 这个连接上传的文件所在的子文件夹
 }
 
+// 数据传输文件默认存储在webdav网盘根目录下的这个文件夹中
+const DefaultWebdavDir = "/tmp_data_transmission/"
+
+// 每个连接在存储文件夹中新建的子文件夹名的前缀,清除时只删除这些变空的子文件夹
+const TransferDirPrefix = "ifss-"
+
+// 本客户端在每个账号中创建的子文件夹和上传的文件,按账号保存,CleanWebdav时一起删除
+var (
+	account_UploadedPathSetMap = make(map[string]map[string]bool)
+	uploadedPathMutex          sync.Mutex
+)
+
 // 配置一个Webdav连接
 func NewWebdavClient(url, localDir, userName, password string) Webdav {
 	client := gowebdav.NewClient(url, userName, password)
@@ -30,17 +51,33 @@ func NewWebdavClient(url, localDir, userName, password string) Webdav {
 		Url:       url,
 		UserName:  userName,
 		Password:  password,
-		WebdavDir: "/tmp_data_transmission/", // 数据传输文件存储在webdav网盘根目录下的这个文件夹中
+		WebdavDir: DefaultWebdavDir,
 		LocalDir:  localDir,
 		Client:    client,
+
+		transferDirName: TransferDirPrefix + filetools.GenerateRandomFileName(filetools.RandomFileNameLength),
 	}
 	filetools.Mkdir(localDir)
 	return w
 }
 
-// 列出Webdav的路径中所有文件的路径
-func (w Webdav) list(fileList *[]*utils.FileStat, path string) {
-	files, _ := w.Client.ReadDir(path)
+// 判断Webdav返回的错误是不是文件或文件夹不存在.
+//  PROPFIND返回的错误为"404 Not Found - PROPFIND 路径",其他请求返回的错误为"404"
+func isNotFound(err error) bool {
+	pathErr, ok := err.(*os.PathError)
+	return ok && strings.HasPrefix(pathErr.Err.Error(), "404")
+}
+
+// 列出Webdav的路径中所有文件的路径,路径不存在时没有文件
+func (w Webdav) list(fileList *[]*utils.FileStat, path string) error {
+	files, err := w.Client.ReadDir(path)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		fmt.Println("无法列出Webdav文件夹", path, err)
+		return err
+	}
 	for _, file := range files {
 		filePath := filepath.Join(path, file.Name())
 		filePath = filepath.ToSlash(filePath) // 防止windows强制转换斜杠的格式
@@ -54,16 +91,24 @@ func (w Webdav) list(fileList *[]*utils.FileStat, path string) {
 				LastModified: file.ModTime().Unix(),
 			}
 			*fileList = append(*fileList, f)
-			w.list(fileList, filePath)
+			err = w.list(fileList, filePath)
+			if err != nil {
+				return err
+			}
 		} else {
 			f := &utils.FileStat{
 				Path:         filePath,
 				FileType:     utils.File,
 				LastModified: file.ModTime().Unix(),
+				Size:         file.Size(),
+			}
+			if webdavFile, ok := file.(gowebdav.File); ok {
+				f.ETag = webdavFile.ETag()
 			}
 			*fileList = append(*fileList, f)
 		}
 	}
+	return err
 }
 
 // 下载单个文件
@@ -100,7 +145,44 @@ func (w Webdav) DownloadFile(webdavDir, localPath string) error {
 	return err
 }
 
-// 上传单个文件
+// 账号的标识,同一个账号的不同连接共用上传记录
+func (w Webdav) accountKey() string {
+	return w.Url + "|" + w.UserName + "|" + w.WebdavDir
+}
+
+// 记录本客户端在这个账号中创建的子文件夹或上传的文件,子文件夹的路径以"/"结尾
+func (w Webdav) recordUploadedPath(webdavPath string) {
+	uploadedPathMutex.Lock()
+	defer uploadedPathMutex.Unlock()
+	uploadedPathSet, isExist := account_UploadedPathSetMap[w.accountKey()]
+	if !isExist {
+		uploadedPathSet = make(map[string]bool)
+		account_UploadedPathSetMap[w.accountKey()] = uploadedPathSet
+	}
+	uploadedPathSet[webdavPath] = true
+}
+
+// 取出并清空本客户端在这个账号中创建的子文件夹和上传的文件的记录
+func (w Webdav) takeUploadedPathList() []string {
+	uploadedPathMutex.Lock()
+	defer uploadedPathMutex.Unlock()
+	var uploadedPathList []string
+	for uploadedPath := range account_UploadedPathSetMap[w.accountKey()] {
+		uploadedPathList = append(uploadedPathList, uploadedPath)
+	}
+	delete(account_UploadedPathSetMap, w.accountKey())
+	return uploadedPathList
+}
+
+// 本客户端是否在这个账号中创建过子文件夹或上传过文件
+func (w Webdav) IsUploaded() bool {
+	uploadedPathMutex.Lock()
+	defer uploadedPathMutex.Unlock()
+	return len(account_UploadedPathSetMap[w.accountKey()]) > 0
+}
+
+// 上传单个文件,大文件按照配置分块上传.上传后用PROPFIND读取文件大小和ETag/校验和来检查是否上传成功,
+//  服务器能提供内容哈希时不需要读回整个文件
 func (w Webdav) UploadFile(webdavDir, localPath string) error {
 	file, err := os.Open(localPath)
 	if err != nil {
@@ -108,9 +190,26 @@ func (w Webdav) UploadFile(webdavDir, localPath string) error {
 		return err
 	}
 	defer file.Close()
-	w.Client.WriteStream(webdavDir, file, 0777)
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	localHash, err := getContentHash(file)
+	if err != nil {
+		return err
+	}
+	if w.isChunkUpload(fileInfo.Size()) {
+		err = w.uploadFileInChunks(webdavDir, file, fileInfo.Size())
+	} else {
+		err = w.Client.WriteStream(webdavDir, file, 0777)
+	}
+	if err != nil {
+		fmt.Println("无法上传到Webdav", err)
+		return err
+	}
+	w.recordUploadedPath(webdavDir) // 检查失败时文件也可能已经写入了,一并记录
 	// 检查文件是否上传成功
-	_, err = w.Client.Read(webdavDir)
+	err = w.verifyUpload(webdavDir, fileInfo.Size(), localHash)
 	if err != nil {
 		fmt.Println("无法上传到Webdav", err)
 		return err
@@ -118,75 +217,293 @@ func (w Webdav) UploadFile(webdavDir, localPath string) error {
 	return err
 }
 
+// 用PROPFIND读取Webdav中文件的属性,检查大小与本地文件一致,再检查内容:
+//  ETag是内容的MD5时比较MD5,否则比较Nextcloud/ownCloud的校验和,都没有时读回文件比较SHA256
+func (w Webdav) verifyUpload(webdavDir string, size int64, localHash contentHash) error {
+	webdavFileInfo, err := w.Client.Stat(webdavDir)
+	if err != nil {
+		return err
+	}
+	if webdavFileInfo.Size() != size {
+		return fmt.Errorf("Webdav中文件%s的大小为%d,与本地文件的大小%d不一致", webdavDir, webdavFileInfo.Size(), size)
+	}
+	if webdavFile, ok := webdavFileInfo.(gowebdav.File); ok {
+		if contentMD5 := getContentMD5FromETag(webdavFile.ETag()); contentMD5 != "" {
+			if contentMD5 != localHash.MD5 {
+				return fmt.Errorf("Webdav中文件%s的ETag为%s,与本地文件的MD5%s不一致", webdavDir, contentMD5, localHash.MD5)
+			}
+			return nil
+		}
+	}
+	checksums, err := w.readChecksums(webdavDir)
+	if err != nil {
+		return err
+	}
+	isCompared, err := compareChecksums(checksums, localHash)
+	if err != nil {
+		return fmt.Errorf("Webdav中文件%s的内容与本地文件不一致: %v", webdavDir, err)
+	}
+	if isCompared {
+		return nil
+	}
+	webdavHash, err := w.readBackHash(webdavDir)
+	if err != nil {
+		return err
+	}
+	if webdavHash != localHash.SHA256 {
+		return fmt.Errorf("Webdav中文件%s的SHA256为%s,与本地文件的%s不一致", webdavDir, webdavHash, localHash.SHA256)
+	}
+	return nil
+}
+
 // 上传一个文件夹中的所有数据交换文件
 func (w Webdav) UploadAllFilesFromFolder(sendProgressChannel chan []byte) error {
 	var err error
-	err = w.Client.Mkdir(w.WebdavDir, 0777) // 如果不存在用来存储数据的临时文件夹,就创建一个
+	err = w.MakeWebdavDir()
 	if err != nil {
-		fmt.Println("无法在Webdav中创建新文件夹", err)
 		return err
 	}
-	filePathList, fileNameList, err := filetools.GenerateUnhiddenFilePathNameListFromFolder(w.LocalDir)
+	_, fileNameList, err := filetools.GenerateUnhiddenFilePathNameListFromFolder(w.LocalDir)
 	if err != nil {
 		return err
 	}
-	for i := 0; i < len(filePathList); i++ {
-		webdavDir := filepath.Join(w.WebdavDir, fileNameList[i])
-		webdavDir = filepath.ToSlash(webdavDir) // 防止windows强制转换斜杠的格式
-		localPath := filePathList[i]
-		err = w.UploadFile(webdavDir, localPath)
-		if err != nil {
-			fmt.Println("无法上传文件到Webdav", err)
-			return err
-		} else {
-			sendProgressChannelJsonBytes := jsontools.GenerateSendProgressChannelJsonBytes(fileNameList[i], w.Url, w.UserName, 1)
-			sendProgressChannel <- sendProgressChannelJsonBytes
-		}
+	// 并行上传,任何一个文件上传失败后不再上传新的文件
+	err = workerpool.Run(w.WorkerNum, len(fileNameList), func(i int) error {
+		return w.UploadFileFromFolder(fileNameList[i], sendProgressChannel)
+	})
+	return err
+}
+
+// 创建这个连接上传文件使用的子文件夹,存储文件夹不存在时一起创建
+func (w Webdav) MakeWebdavDir() error {
+	err := w.Client.MkdirAll(w.transferDir(), 0777)
+	if err != nil {
+		fmt.Println("无法在Webdav中创建新文件夹", err)
+		return err
+	}
+	w.recordUploadedPath(w.transferDir())
+	return err
+}
+
+// 这个连接上传文件使用的子文件夹
+func (w Webdav) transferDir() string {
+	return filepath.ToSlash(filepath.Join(w.WebdavDir, w.transferDirName)) + "/"
+}
+
+// 上传本地文件夹中的一个数据交换文件到这个连接的子文件夹中
+func (w Webdav) UploadFileFromFolder(fileName string, sendProgressChannel chan []byte) error {
+	webdavDir := filepath.Join(w.transferDir(), fileName)
+	webdavDir = filepath.ToSlash(webdavDir) // 防止windows强制转换斜杠的格式
+	localPath := filepath.Join(w.LocalDir, fileName)
+	err := w.UploadFile(webdavDir, localPath)
+	if err != nil {
+		fmt.Println("无法上传文件到Webdav", err)
+		return err
 	}
+	sendProgressChannelJsonBytes := jsontools.GenerateSendProgressChannelJsonBytes(fileName, w.Url, w.UserName, 1)
+	sendProgressChannel <- sendProgressChannelJsonBytes
 	return err
 }
 
-// 下载一个文件夹里面的所有数据交换文件
+// 下载存储文件夹及其子文件夹中所有新的数据交换文件.已经下载过、并且ETag(服务器不提供时为修改时间和大小)没有变化的文件会被跳过,
+//  探测文件和其他隐藏文件也会被跳过;上次下载的文件已经交给接收流程处理过了,下载前会被删除
 func (w Webdav) DownloadAllFilesToFolder(receiveProgressChannel chan []byte) error {
 	var err error
+	path_TagMap, err := w.readIndex()
+	if err != nil {
+		return err
+	}
+	err = removeDownloadedFiles(w.LocalDir)
+	if err != nil {
+		return err
+	}
 	var webdavFileStatList = make([]*utils.FileStat, 0)
-	w.list(&webdavFileStatList, w.WebdavDir)
+	err = w.list(&webdavFileStatList, w.WebdavDir)
+	if err != nil {
+		return err
+	}
+	// 只保留仍然在Webdav中的文件的记录
+	newPath_TagMap := make(map[string]string)
+	for _, webdavFileStat := range webdavFileStatList {
+		if tag, isExist := path_TagMap[webdavFileStat.Path]; isExist {
+			newPath_TagMap[webdavFileStat.Path] = tag
+		}
+	}
 	for _, webdavFileStat := range webdavFileStatList {
 		webdavFilePath := webdavFileStat.Path
 		_, webdavFileName := filepath.Split(webdavFilePath)
+		if webdavFileStat.FileType != utils.File || strings.HasPrefix(webdavFileName, ".") {
+			continue
+		}
+		tag := getFileTag(webdavFileStat)
+		if path_TagMap[webdavFilePath] == tag { // 已经下载过
+			continue
+		}
 		localPath := filepath.Join(w.LocalDir, webdavFileName)
 		err = w.DownloadFile(webdavFilePath, localPath)
 		if err != nil {
 			fmt.Println("无法从Webdav下载文件", err)
+			w.writeIndex(newPath_TagMap) // 记录已经下载成功的文件
 			return err
-		} else {
-			receiveProgressChannelJsonBytes := jsontools.GenerateReceiveProgressChannelJsonBytes(webdavFileName, w.Url, w.UserName)
-			receiveProgressChannel <- receiveProgressChannelJsonBytes
 		}
+		newPath_TagMap[webdavFilePath] = tag
+		receiveProgressChannelJsonBytes := jsontools.GenerateReceiveProgressChannelJsonBytes(webdavFileName, w.Url, w.UserName)
+		receiveProgressChannel <- receiveProgressChannelJsonBytes
 	}
+	err = w.writeIndex(newPath_TagMap)
 	return err
 }
 
-// 清除之前使用Webdav下载过的文件
+// 删除本地文件夹中上次下载的数据交换文件,索引文件和其他隐藏文件不受影响
+func removeDownloadedFiles(localDir string) error {
+	filePathList, _, err := filetools.GenerateUnhiddenFilePathNameListFromFolder(localDir)
+	if err != nil {
+		return err
+	}
+	for _, filePath := range filePathList {
+		err = filetools.RmFile(filePath)
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// 清除之前使用Webdav下载过的文件和本客户端上传过的文件:只删除索引和上传记录中的文件,
+//  以及本客户端创建的和因此变空的子文件夹
 func (w Webdav) CleanWebdav() error {
 	var err error
-	_, fileNameList, err := filetools.GenerateUnhiddenFilePathNameListFromFolder(w.LocalDir)
+	path_TagMap, err := w.readIndex()
 	if err != nil {
 		return err
 	}
-	if len(fileNameList) == 0 {
-		fmt.Println("没有在", w.Url, "中检测到需要下载的内容")
+	uploadedPathList := w.takeUploadedPathList()
+	if len(path_TagMap) == 0 && len(uploadedPathList) == 0 {
+		fmt.Println("没有在", w.Url, "中检测到需要清除的内容")
 		return err
 	}
-	for _, fileName := range fileNameList {
-		webdavDir := filepath.Join(w.WebdavDir, fileName)
-		webdavDir = filepath.ToSlash(webdavDir) // 防止windows强制转换斜杠的格式
-		err = w.Client.Remove(webdavDir)
+	var removedPathList []string
+	for webdavFilePath := range path_TagMap {
+		err = w.Client.Remove(webdavFilePath)
 		if err != nil {
 			fmt.Println("无法清除Webdav的文件", err)
 			return err
 		}
+		removedPathList = append(removedPathList, webdavFilePath)
+		delete(path_TagMap, webdavFilePath)
+	}
+	err = w.writeIndex(path_TagMap)
+	if err != nil {
+		return err
+	}
+	// 子文件夹的路径以"/"结尾,filepath.Dir得到的就是子文件夹本身,由removeEmptyTransferDirs删除
+	for _, uploadedPath := range uploadedPathList {
+		if !strings.HasSuffix(uploadedPath, "/") {
+			err = w.Client.Remove(uploadedPath)
+			if err != nil && !isNotFound(err) { // 可能已经被接收方删除了
+				fmt.Println("无法清除Webdav的文件", err)
+				return err
+			}
+		}
+		removedPathList = append(removedPathList, uploadedPath)
+	}
+	err = w.removeEmptyTransferDirs(removedPathList)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Webdav", w.Url, "中的", removedPathList, "已被成功清除", "使用的账户为", w.UserName)
+	return err
+}
+
+// 只删除Webdav中指定文件名的文件,不影响其他文件.文件可能在任何一个连接的子文件夹中
+func (w Webdav) RemoveFileList(fileNameList []string) error {
+	var err error
+	fileNameSet := make(map[string]bool)
+	for _, fileName := range fileNameList {
+		fileNameSet[fileName] = true
+	}
+	var webdavFileStatList = make([]*utils.FileStat, 0)
+	err = w.list(&webdavFileStatList, w.WebdavDir)
+	if err != nil {
+		return err
+	}
+	var removedPathList []string
+	for _, webdavFileStat := range webdavFileStatList {
+		_, webdavFileName := filepath.Split(webdavFileStat.Path)
+		if webdavFileStat.FileType != utils.File || !fileNameSet[webdavFileName] {
+			continue
+		}
+		err = w.Client.Remove(webdavFileStat.Path)
+		if err != nil {
+			fmt.Println("无法删除Webdav的文件", err)
+			return err
+		}
+		removedPathList = append(removedPathList, webdavFileStat.Path)
+	}
+	return w.removeEmptyTransferDirs(removedPathList)
+}
+
+// 删除文件之后,删除这些文件所在的、已经变空的以TransferDirPrefix开头的子文件夹
+func (w Webdav) removeEmptyTransferDirs(removedPathList []string) error {
+	dirSet := make(map[string]bool)
+	for _, removedPath := range removedPathList {
+		dir := filepath.ToSlash(filepath.Dir(removedPath))
+		if strings.HasPrefix(filepath.Base(dir), TransferDirPrefix) {
+			dirSet[dir] = true
+		}
+	}
+	for dir := range dirSet {
+		files, err := w.Client.ReadDir(dir)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			continue
+		}
+		err = w.Client.Remove(dir)
+		if err != nil {
+			fmt.Println("无法删除Webdav的文件夹", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// 检查账号是否可用:先验证登录,再用PROPFIND读取数据文件夹的属性和剩余空间,不在Webdav中写入任何文件.
+//  数据文件夹还不存在时上传会创建它;服务器提供剩余空间并且已经没有空间时认为不可用
+func (w Webdav) CheckHealth() error {
+	var err error
+	defer func() {
+		if err != nil {
+			fmt.Println("Webdav账号", w.UserName, "在", w.Url, "不可用", err)
+		}
+	}()
+	err = w.Client.Connect()
+	if err != nil {
+		return err
+	}
+	quotaDir := w.WebdavDir
+	webdavDirInfo, err := w.Client.Stat(w.WebdavDir)
+	switch {
+	case isNotFound(err):
+		quotaDir = "/"
+		err = nil
+	case err != nil:
+		return err
+	case !webdavDirInfo.IsDir():
+		err = fmt.Errorf("Webdav中的%s不是文件夹", w.WebdavDir)
+		return err
+	}
+	quotaAvailableBytes, err := w.readQuotaAvailableBytes(quotaDir)
+	if err != nil {
+		return err
+	}
+	if quotaAvailableBytes == 0 {
+		err = fmt.Errorf("Webdav中已经没有剩余空间")
+		return err
 	}
-	fmt.Println("Webdav", w.Url, "中的", fileNameList, "已被成功清除", "使用的账户为", w.UserName)
 	return err
 }
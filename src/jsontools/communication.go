@@ -8,8 +8,12 @@ import (
 	"math/big"
 	// "strconv"
 	"os"
+	"strings"
 )
 
+// 多个接收方的代号连接成一个字符串时使用的分隔符
+const ReceiverNameSeparator = ","
+
 // 前端生成的发送阶段配置json文件,返回生成的json的bytes
 func GenerateSendStrategyJsonBytes(divideMethod, groupNum int, senderName, receiverName, srcFilePath string, timer int) []byte {
 	jsonParser := GenerateNewJsonParser()
@@ -27,6 +31,25 @@ func GenerateSendStrategyJsonBytes(divideMethod, groupNum int, senderName, recei
 	return jsonParser.GenerateJsonBytes()
 }
 
+// 前端生成的发送给多个接收方的发送阶段配置json文件,返回生成的json的bytes
+func GenerateMultiReceiverSendStrategyJsonBytes(divideMethod, groupNum int, senderName string, receiverNameList []string, srcFilePath string, timer int) []byte {
+	jsonParser, _ := ReadJsonBytes(GenerateSendStrategyJsonBytes(divideMethod, groupNum, senderName, strings.Join(receiverNameList, ReceiverNameSeparator), srcFilePath, timer))
+	jsonParser.SetValue(receiverNameList, "ReceiverNameList")
+	return jsonParser.GenerateJsonBytes()
+}
+
+// 从发送阶段配置中读取所有接收方的代号
+func ReadReceiverNameList(sendStrategyJsonParser *JsonParser) []string {
+	if !sendStrategyJsonParser.IsPathExists("/ReceiverNameList") {
+		return []string{sendStrategyJsonParser.ReadJsonValue("/ReceiverName").(string)}
+	}
+	var receiverNameList []string
+	for _, receiverName := range sendStrategyJsonParser.ReadJsonValue("/ReceiverNameList").([]interface{}) {
+		receiverNameList = append(receiverNameList, receiverName.(string))
+	}
+	return receiverNameList
+}
+
 // 反馈给发送进程的发送进度
 func GenerateSendProgressChannelJsonBytes(fileName, url, userName string, sendNum int) ([]byte,) {
 	jsonParser := GenerateNewJsonParser()
@@ -46,6 +69,17 @@ func GenerateReceiveProgressChannelJsonBytes(fileName, url, userName string) []b
 	return jsonParser.GenerateJsonBytes()
 }
 
+// 反馈给前端的一个IFSS账号的上传/下载结果,msgType为uploadResult或downloadResult,没有出错时errString为空
+func GenerateAccountResultJsonBytes(msgType, ifssName string, attemptNum, successNum int, errString string) []byte {
+	jsonParser := GenerateNewJsonParser()
+	jsonParser.SetValue(msgType, "MsgType")
+	jsonParser.SetValue(ifssName, "IFSSName")
+	jsonParser.SetValue(attemptNum, "AttemptNum")
+	jsonParser.SetValue(successNum, "SuccessNum")
+	jsonParser.SetValue(errString, "Error")
+	return jsonParser.GenerateJsonBytes()
+}
+
 // 反馈给前端的发送进度
 func GenerateSendProgressJsonBytes(srcFilePath string, identification, successSendNum, totalNum int) []byte {
 	jsonParser := GenerateNewJsonParser()
@@ -57,7 +91,7 @@ func GenerateSendProgressJsonBytes(srcFilePath string, identification, successSe
 }
 
 // 反馈给前端的组合进度
-func GenerateRestoreProgressJsonBytes(dstFilePath, senderName, receiverName string, fileDataLength, identification, successReceiveNum, totalNum int) []byte {
+func GenerateRestoreProgressJsonBytes(dstFilePath, senderName, receiverName, senderKeyFingerprint, senderKeyFingerprintEmoji, senderKeyPinState string, fileDataLength, identification, successReceiveNum, totalNum int) []byte {
 	jsonParser := GenerateNewJsonParser()
 	jsonParser.SetValue("receiveProgress", "MsgType")
 	jsonParser.SetValue(fileDataLength, "FileDataLength")
@@ -66,6 +100,9 @@ func GenerateRestoreProgressJsonBytes(dstFilePath, senderName, receiverName stri
 	// jsonParser.SetValue(relativePath, "DstFilePath")
 	jsonParser.SetValue(senderName, "SenderName")
 	jsonParser.SetValue(receiverName, "ReceiverName")
+	jsonParser.SetValue(senderKeyFingerprint, "SenderKeyFingerprint")
+	jsonParser.SetValue(senderKeyFingerprintEmoji, "SenderKeyFingerprintEmoji")
+	jsonParser.SetValue(senderKeyPinState, "SenderKeyPinState")
 	jsonParser.SetValue(fileName, "FileName")
 	jsonParser.SetValue(identification, "Identification")
 	jsonParser.SetValue(int(100*float64(successReceiveNum)/float64(totalNum)), "Percentage")
@@ -16,11 +16,15 @@ type JsonParser struct {
 
 // 存储IFSS信息
 type IFSSInfo struct {
-	IFSSName         string // 操作IFSS的名称
-	IFSSType         string // IFSS类型(如git,webdav)
-	IFSSURL          string // IFSS的URL
-	IFSSUserName     string // 操作IFSS使用的账户
-	IFSSUserPassword string // 账户的密码
+	IFSSName              string // 操作IFSS的名称
+	IFSSType              string // IFSS类型(如git,webdav)
+	IFSSURL               string // IFSS的URL
+	IFSSUserName          string // 操作IFSS使用的账户
+	IFSSUserPassword      string // 账户的密码,git使用token认证时为访问令牌
+	IFSSAuthType          string // git的认证方式(basic,token,ssh,ssh-agent),为空时使用basic
+	IFSSSSHKeyPath        string // git使用ssh认证时的私钥文件
+	IFSSSSHKeyPassphrase  string // 私钥的密码,私钥没有加密时为空
+	IFSSSSHKnownHostsPath string // 校验服务器公钥使用的known_hosts文件,为空时使用系统默认的known_hosts
 }
 
 // 生成一个空的jsonparser
@@ -76,6 +80,12 @@ func (j *JsonParser) ReadJsonValue(path string) interface{} {
 	return gObj.Data()
 }
 
+// 判断json中是否存在path对应的value
+func (j *JsonParser) IsPathExists(path string) bool {
+	_, err := j.Parser.JSONPointer(path)
+	return err == nil
+}
+
 // 获取json数组中的所有成员
 func (j *JsonParser) GetAllChildren(path string) []*JsonParser {
 	var childrenList []*JsonParser
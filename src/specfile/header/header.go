@@ -21,10 +21,19 @@ type Header struct {
 	GroupSN        int8      // 冗余分组序列号
 	FragmentSN     int8      // 数据分片序号(如果是冗余分片,则序号为-1)
 	GroupContent   [8]int8   // 本冗余分组中所有数据分片的FragmentSN
+	KeyExchange    int8      // 对称密钥的交换方式(见KEY_EXCHANGE_*)
+	RecipientNum   int8      // 共用同一份数据分片的接收方数量
+	RecipientSN    int8      // 本头部所在的接收方槽的序号
 }
 
+// 对称密钥的交换方式
+const (
+	KEY_EXCHANGE_RSA    int8 = 0 // 对称密钥使用接收方RSA公钥加密后写入数据交换文件
+	KEY_EXCHANGE_X25519 int8 = 1 // 数据交换文件中只保存发送方的临时X25519公钥,对称密钥由HKDF派生
+)
+
 // 生成一个头部结构体,并将头部结构体转为对应的bytes
-func GenerateHeaderBytes(senderName, receiverName, fileName string, identification, fileDataLength, timer int32, divideMethod, groupNum, groupSN, fragmentSN int8, groupContent []int8) ([]byte, error) {
+func GenerateHeaderBytes(senderName, receiverName, fileName string, identification, fileDataLength, timer int32, divideMethod, groupNum, groupSN, fragmentSN int8, groupContent []int8, keyExchange, recipientNum, recipientSN int8) ([]byte, error) {
 	var header *Header = &Header{}
 	header.SetSenderName(senderName)
 	header.SetReceiverName(receiverName)
@@ -37,6 +46,9 @@ func GenerateHeaderBytes(senderName, receiverName, fileName string, identificati
 	header.SetGroupSN(groupSN)
 	header.SetFragmentSN(fragmentSN)
 	header.SetGroupContent(groupContent)
+	header.SetKeyExchange(keyExchange)
+	header.SetRecipientNum(recipientNum)
+	header.SetRecipientSN(recipientSN)
 	headerBytes, err := header.HeaderToBytes()
 	return headerBytes, err
 }
@@ -157,6 +169,21 @@ func (h Header) GetGroupContent() []int8 {
 	return groupContent
 }
 
+// 获得KeyExchange
+func (h Header) GetKeyExchange() int8 {
+	return h.KeyExchange
+}
+
+// 获得RecipientNum
+func (h Header) GetRecipientNum() int8 {
+	return h.RecipientNum
+}
+
+// 获得RecipientSN
+func (h Header) GetRecipientSN() int8 {
+	return h.RecipientSN
+}
+
 // 设定SenderName
 func (h *Header) SetSenderName(senderName string) {
 	senderNameBytes := []byte(senderName)
@@ -215,3 +242,18 @@ func (h *Header) SetGroupContent(groupContent []int8) {
 	(*h).GroupContent = [8]int8{-1, -1, -1, -1, -1, -1, -1, -1}
 	copy((*h).GroupContent[:len(groupContent)], groupContent)
 }
+
+// 设定KeyExchange
+func (h *Header) SetKeyExchange(keyExchange int8) {
+	(*h).KeyExchange = keyExchange
+}
+
+// 设定RecipientNum
+func (h *Header) SetRecipientNum(recipientNum int8) {
+	(*h).RecipientNum = recipientNum
+}
+
+// 设定RecipientSN
+func (h *Header) SetRecipientSN(recipientSN int8) {
+	(*h).RecipientSN = recipientSN
+}
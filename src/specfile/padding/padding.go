@@ -1,17 +1,126 @@
 // 生成一段定长的随机bytes.
+//  填充策略决定了数据交换文件最终的大小,避免观察者根据文件大小推测出原文件的大小.
 package padding
 
 import (
 	"crypto/rand"
+	"fmt"
 	"io"
 	"math/big"
+	"sort"
 )
 
+type PaddingPolicy string
+
+// 不同的填充策略
+const (
+	PADDING_RANDOM           PaddingPolicy = "random"           // 在原文件大小的1/3以内随机填充
+	PADDING_BUCKET_POW2      PaddingPolicy = "bucket_pow2"      // 填充到不小于数据交换文件大小的最小的2的幂
+	PADDING_BUCKET_FIXED     PaddingPolicy = "bucket_fixed"     // 填充到给定的大小等级中不小于数据交换文件大小的最小一级
+	PADDING_CONSTANT         PaddingPolicy = "constant"         // 所有数据交换文件都填充到同一个固定大小
+	PADDING_RANDOM_IN_BUCKET PaddingPolicy = "random_in_bucket" // 先确定所在的大小等级,再在等级内随机选择最终大小
+)
+
+// 按2的幂分桶时最小的桶的大小
+const MinBucketSize = 1024
+
+// 填充策略及其参数
+type Policy struct {
+	Type          PaddingPolicy
+	SizeClassList []int // PADDING_BUCKET_FIXED和PADDING_RANDOM_IN_BUCKET使用的大小等级,为空时按2的幂分桶
+	ConstantSize  int   // PADDING_CONSTANT使用的固定大小
+}
+
 // 生成随机长度的填充字节流
 func GeneratePadding(max int) []byte {
+	if max <= 0 { // 原文件过小时不填充
+		return []byte{}
+	}
 	n, _ := rand.Int(rand.Reader, big.NewInt(int64(max)))
 	paddingLength := int(n.Int64())
+	return GeneratePaddingWithLength(paddingLength)
+}
+
+// 生成指定长度的填充字节流
+func GeneratePaddingWithLength(paddingLength int) []byte {
 	padding := make([]byte, paddingLength)
 	io.ReadFull(rand.Reader, padding)
 	return padding
 }
+
+// 找到不小于length的最小的2的幂
+func getPow2BucketSize(length int) int {
+	bucketSize := MinBucketSize
+	for bucketSize < length {
+		bucketSize *= 2
+	}
+	return bucketSize
+}
+
+// 在大小等级中找到不小于length的最小一级,没有配置大小等级时按2的幂分桶
+func getBucketSize(policy Policy, length int) (int, error) {
+	if len(policy.SizeClassList) == 0 {
+		return getPow2BucketSize(length), nil
+	}
+	sizeClassList := append([]int{}, policy.SizeClassList...)
+	sort.Ints(sizeClassList)
+	for _, sizeClass := range sizeClassList {
+		if sizeClass >= length {
+			return sizeClass, nil
+		}
+	}
+	err := fmt.Errorf("数据交换文件大小%d超过了最大的大小等级%d", length, sizeClassList[len(sizeClassList)-1])
+	fmt.Println(err)
+	return 0, err
+}
+
+// 根据填充策略,计算长度为specFileLength的数据交换文件需要填充的长度
+func CalculatePaddingLength(policy Policy, specFileLength, fileDataLength int) (int, error) {
+	switch policy.Type {
+	case PADDING_RANDOM, "":
+		if fileDataLength/3 <= 0 {
+			return 0, nil
+		}
+		n, _ := rand.Int(rand.Reader, big.NewInt(int64(fileDataLength/3)))
+		return int(n.Int64()), nil
+	case PADDING_BUCKET_POW2:
+		return getPow2BucketSize(specFileLength) - specFileLength, nil
+	case PADDING_BUCKET_FIXED:
+		bucketSize, err := getBucketSize(policy, specFileLength)
+		if err != nil {
+			return 0, err
+		}
+		return bucketSize - specFileLength, nil
+	case PADDING_CONSTANT:
+		if specFileLength > policy.ConstantSize {
+			err := fmt.Errorf("数据交换文件大小%d超过了固定大小%d,请增加分片数量或增大固定大小", specFileLength, policy.ConstantSize)
+			fmt.Println(err)
+			return 0, err
+		}
+		return policy.ConstantSize - specFileLength, nil
+	case PADDING_RANDOM_IN_BUCKET:
+		bucketSize, err := getBucketSize(policy, specFileLength)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := rand.Int(rand.Reader, big.NewInt(int64(bucketSize-specFileLength+1)))
+		return int(n.Int64()), nil
+	default:
+		err := fmt.Errorf("填充策略%s不合法", policy.Type)
+		fmt.Println(err)
+		return 0, err
+	}
+}
+
+// 根据填充策略,计算长度为specFileLength的数据交换文件填充之后可能达到的最大长度
+func CalculateMaxPaddedLength(policy Policy, specFileLength, fileDataLength int) (int, error) {
+	switch policy.Type {
+	case PADDING_RANDOM, "":
+		return specFileLength + fileDataLength/3, nil
+	case PADDING_RANDOM_IN_BUCKET:
+		return getBucketSize(policy, specFileLength)
+	default:
+		paddingLength, err := CalculatePaddingLength(policy, specFileLength, fileDataLength)
+		return specFileLength + paddingLength, err
+	}
+}
@@ -0,0 +1,82 @@
+package padding
+
+import (
+	"testing"
+)
+
+func TestCalculatePaddingLength(t *testing.T) {
+	testCaseList := []struct {
+		name           string
+		policy         Policy
+		specFileLength int
+		fileDataLength int
+		wantMin        int // 填充后的最小长度
+		wantMax        int // 填充后的最大长度
+		wantErr        bool
+	}{
+		{"随机填充", Policy{Type: PADDING_RANDOM}, 1000, 900, 1000, 1299, false},
+		{"未指定策略时随机填充", Policy{}, 1000, 900, 1000, 1299, false},
+		{"原文件过小时不填充", Policy{Type: PADDING_RANDOM}, 100, 2, 100, 100, false},
+		{"2的幂分桶", Policy{Type: PADDING_BUCKET_POW2}, 5000, 0, 8192, 8192, false},
+		{"2的幂分桶的最小桶", Policy{Type: PADDING_BUCKET_POW2}, 10, 0, MinBucketSize, MinBucketSize, false},
+		{"恰好等于2的幂", Policy{Type: PADDING_BUCKET_POW2}, 4096, 0, 4096, 4096, false},
+		{"固定大小等级", Policy{Type: PADDING_BUCKET_FIXED, SizeClassList: []int{65536, 4096, 16384}}, 5000, 0, 16384, 16384, false},
+		{"固定大小等级为空时按2的幂分桶", Policy{Type: PADDING_BUCKET_FIXED}, 5000, 0, 8192, 8192, false},
+		{"超过最大的大小等级", Policy{Type: PADDING_BUCKET_FIXED, SizeClassList: []int{1024, 2048}}, 5000, 0, 0, 0, true},
+		{"固定大小", Policy{Type: PADDING_CONSTANT, ConstantSize: 10000}, 5000, 0, 10000, 10000, false},
+		{"超过固定大小", Policy{Type: PADDING_CONSTANT, ConstantSize: 4000}, 5000, 0, 0, 0, true},
+		{"等级内随机", Policy{Type: PADDING_RANDOM_IN_BUCKET, SizeClassList: []int{4096, 16384}}, 5000, 0, 5000, 16384, false},
+		{"等级内随机超过最大等级", Policy{Type: PADDING_RANDOM_IN_BUCKET, SizeClassList: []int{4096}}, 5000, 0, 0, 0, true},
+		{"策略不合法", Policy{Type: "unknown"}, 5000, 0, 0, 0, true},
+	}
+	for _, testCase := range testCaseList {
+		t.Run(testCase.name, func(t *testing.T) {
+			for i := 0; i < 20; i++ { // 随机策略多试几次
+				paddingLength, err := CalculatePaddingLength(testCase.policy, testCase.specFileLength, testCase.fileDataLength)
+				if testCase.wantErr {
+					if err == nil {
+						t.Fatalf("应当返回错误,实际填充长度为%d", paddingLength)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("返回了错误: %v", err)
+				}
+				paddedLength := testCase.specFileLength + paddingLength
+				if paddedLength < testCase.wantMin || paddedLength > testCase.wantMax {
+					t.Fatalf("填充后长度为%d,应在%d和%d之间", paddedLength, testCase.wantMin, testCase.wantMax)
+				}
+				maxPaddedLength, err := CalculateMaxPaddedLength(testCase.policy, testCase.specFileLength, testCase.fileDataLength)
+				if err != nil {
+					t.Fatalf("计算最大长度返回了错误: %v", err)
+				}
+				if paddedLength > maxPaddedLength {
+					t.Fatalf("填充后长度%d超过了最大长度%d", paddedLength, maxPaddedLength)
+				}
+			}
+		})
+	}
+}
+
+func TestGeneratePadding(t *testing.T) {
+	testCaseList := []struct {
+		name string
+		max  int
+	}{
+		{"不填充", 0},
+		{"负数不填充", -5},
+		{"一个字节以内", 1},
+		{"随机长度", 100},
+	}
+	for _, testCase := range testCaseList {
+		t.Run(testCase.name, func(t *testing.T) {
+			padding := GeneratePadding(testCase.max)
+			if len(padding) > 0 && len(padding) >= testCase.max {
+				t.Errorf("填充长度为%d,应小于%d", len(padding), testCase.max)
+			}
+		})
+	}
+	if length := len(GeneratePaddingWithLength(37)); length != 37 {
+		t.Errorf("指定长度的填充长度为%d,应为37", length)
+	}
+}
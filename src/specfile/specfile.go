@@ -3,24 +3,38 @@ package specfile
 
 import (
 	"bytes"
-	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"fmt"
-	"math/big"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"xindauserbackground/src/crypto/aestools"
+	"xindauserbackground/src/crypto/grouptools"
+	"xindauserbackground/src/crypto/keypin"
 	"xindauserbackground/src/crypto/rsatools"
+	"xindauserbackground/src/crypto/x25519tools"
 	"xindauserbackground/src/filetools"
 	"xindauserbackground/src/jsontools"
 	"xindauserbackground/src/specfile/fragment"
 	"xindauserbackground/src/specfile/header"
 	"xindauserbackground/src/specfile/padding"
 	redundance "xindauserbackground/src/specfile/redudance"
+	"xindauserbackground/src/workerpool"
 )
 
+// 共用同一份数据分片的接收方的最大数量
+const MaxReceiverNum = 16
+
+// 多接收方时每个槽末尾的密钥提示的长度.接收方先比对提示,只对提示相符的槽尝试私钥解密
+const KeyHintSize = 4
+
+// 发送方本地的清单文件名,隐藏文件不会被上传
+const ManifestFileName = ".manifest"
+
 // 每个段的信息
 type StructureInfo struct {
 	Start  int
@@ -42,6 +56,7 @@ type FileInfo struct {
 	UnencryptedFileStructure FileStructure
 	EncryptedFileStructure   FileStructure
 	Header                   header.Header
+	ReceiverPrivateKey       *rsa.PrivateKey // 能够解密该数据交换文件的私钥(本方私钥或所属组的组私钥)
 }
 
 // 每个组的数据交换文件的摘要信息
@@ -87,12 +102,118 @@ func generateFileStructure(h header.Header) (unencryptedFileStructure FileStruct
 	encryptedSignStart := encryptedFragmentStart + encryptedFragmentLength
 	encryptedSignLength := rsatools.GetCiphertextLength(unencryptedSignLength)
 	encryptedSignStructure := StructureInfo{encryptedSignStart, encryptedSignLength}
+	// 多个接收方时,每个接收方占用一个槽(头部/对称密钥/Nonce/签名),所有接收方共用槽之后的同一份加密分片
+	if h.GetRecipientNum() > 1 {
+		slotStart := int(h.GetRecipientSN()) * getReceiverSlotLength()
+		encryptedHeaderStructure.Start = slotStart
+		encryptedSymmetricKeyStructure.Start = encryptedHeaderStructure.Start + encryptedHeaderLength
+		encryptedNonceStructure.Start = encryptedSymmetricKeyStructure.Start + encryptedSymmetricKeyLength
+		encryptedSignStructure.Start = encryptedNonceStructure.Start + encryptedNonceLength
+		encryptedFragmentStructure.Start = int(h.GetRecipientNum()) * getReceiverSlotLength()
+	}
 	// 生成未加密数据交换文件&加密数据交换文件的FileStructure
 	unencryptedFileStructure = FileStructure{unencryptedHeaderStructure, unencryptedSymmetricKeyStructure, unencryptedNonceStructure, unencryptedFragmentStructure, unencryptedSignStructure}
 	encryptedFileStructure = FileStructure{encryptedHeaderStructure, encryptedSymmetricKeyStructure, encryptedNonceStructure, encryptedFragmentStructure, encryptedSignStructure}
 	return
 }
 
+// 获得加密后的头部/对称密钥/Nonce/签名所占用的空间
+func getSlotCiphertextLength() int {
+	return rsatools.GetCiphertextLength(header.GetHeaderBytesSize()) + rsatools.GetCiphertextLength(256/8) + rsatools.GetCiphertextLength(12) + rsatools.GetCiphertextLength(128)
+}
+
+// 获得多接收方时每个接收方的槽所占用的空间,槽的末尾是密钥提示
+func getReceiverSlotLength() int {
+	return getSlotCiphertextLength() + KeyHintSize
+}
+
+// 计算一个槽的密钥提示:接收方公钥与该槽加密后的头部一起做哈希.
+//  加密后的头部带有随机填充,所以同一个接收方在不同文件中的提示不同,无法用来关联文件
+func generateKeyHint(receiverPublicKey *rsa.PublicKey, encryptedHeaderBytes []byte) ([]byte, error) {
+	fingerprint, err := rsatools.GetPublicKeyFingerprint(receiverPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(bytesCombine(fingerprint, encryptedHeaderBytes))
+	return hash[:KeyHintSize], nil
+}
+
+// 读取本方私钥,以及本方所属的所有组的组私钥
+func readReceiverPrivateKeyList(receiverPrivateKeyFilePath string, userListParser *jsontools.JsonParser) ([]*rsa.PrivateKey, error) {
+	receiverPrivateKey, err := rsatools.ReadPrivateKeyFile(receiverPrivateKeyFilePath)
+	if err != nil {
+		return nil, err
+	}
+	receiverPrivateKeyList := []*rsa.PrivateKey{receiverPrivateKey}
+	for _, groupPrivateKey := range grouptools.ReadGroupPrivateKeyMap(userListParser, receiverPrivateKey) {
+		receiverPrivateKeyList = append(receiverPrivateKeyList, groupPrivateKey)
+	}
+	return receiverPrivateKeyList, nil
+}
+
+// 从数据交换文件中读取并解密出头部,并返回能解密该文件的私钥.
+//  多接收方的数据交换文件中有多个槽,只对密钥提示和本方私钥或组私钥相符的槽尝试解密,解密成功即停止;
+//  没有相符的槽时,按单接收方的数据交换文件尝试解密第一个槽
+func readHeaderFromSpecFile(filePath string, receiverPrivateKeyList []*rsa.PrivateKey) (header.Header, *rsa.PrivateKey, error) {
+	var err error
+	f, err := os.Open(filePath)
+	if err != nil {
+		fmt.Println("无法打开数据交换文件", filePath)
+		return header.Header{}, nil, err
+	}
+	defer f.Close()
+	fileInfo, err := f.Stat()
+	if err != nil {
+		fmt.Println("无法读取数据交换文件的信息", filePath)
+		return header.Header{}, nil, err
+	}
+	encryptedHeaderLength := rsatools.GetCiphertextLength(header.GetHeaderBytesSize())
+	// 用私钥解密一个槽的头部,槽号与头部中记录的不一致时视为解密失败
+	tryDecryptHeader := func(encryptedHeaderBytes []byte, receiverPrivateKey *rsa.PrivateKey, recipientSN int) (header.Header, bool) {
+		unencryptedHeaderBytes, err := rsatools.TryDecryptWithPrivateKey(encryptedHeaderBytes, receiverPrivateKey)
+		if err != nil || len(unencryptedHeaderBytes) != header.GetHeaderBytesSize() {
+			return header.Header{}, false
+		}
+		h, err := header.BytesToHeader(unencryptedHeaderBytes)
+		if err != nil || int(h.GetRecipientSN()) != recipientSN {
+			return header.Header{}, false
+		}
+		return h, true
+	}
+	for recipientSN := 0; recipientSN < MaxReceiverNum; recipientSN++ {
+		encryptedHeaderStart := recipientSN * getReceiverSlotLength()
+		if int64(encryptedHeaderStart+getReceiverSlotLength()) > fileInfo.Size() {
+			break
+		}
+		encryptedHeaderBytes := make([]byte, encryptedHeaderLength)
+		f.ReadAt(encryptedHeaderBytes, int64(encryptedHeaderStart)) // 将头部读取到headerBytes里面
+		keyHint := make([]byte, KeyHintSize)
+		f.ReadAt(keyHint, int64(encryptedHeaderStart+getSlotCiphertextLength()))
+		for _, receiverPrivateKey := range receiverPrivateKeyList {
+			expectedKeyHint, err := generateKeyHint(&receiverPrivateKey.PublicKey, encryptedHeaderBytes)
+			if err != nil || !bytes.Equal(keyHint, expectedKeyHint) {
+				continue
+			}
+			if h, ok := tryDecryptHeader(encryptedHeaderBytes, receiverPrivateKey, recipientSN); ok {
+				return h, receiverPrivateKey, nil
+			}
+		}
+	}
+	// 单接收方的数据交换文件没有槽和密钥提示
+	if int64(encryptedHeaderLength) <= fileInfo.Size() {
+		encryptedHeaderBytes := make([]byte, encryptedHeaderLength)
+		f.ReadAt(encryptedHeaderBytes, 0)
+		for _, receiverPrivateKey := range receiverPrivateKeyList {
+			if h, ok := tryDecryptHeader(encryptedHeaderBytes, receiverPrivateKey, 0); ok && h.GetRecipientNum() <= 1 {
+				return h, receiverPrivateKey, nil
+			}
+		}
+	}
+	err = fmt.Errorf("无法用本方私钥解密数据交换文件%s的头部", filePath)
+	fmt.Println(err)
+	return header.Header{}, nil, err
+}
+
 // 多个[]byte数组合并成一个[]byte
 func bytesCombine(pBytes ...[]byte) []byte {
 	len := len(pBytes)
@@ -103,97 +224,255 @@ func bytesCombine(pBytes ...[]byte) []byte {
 	return buffer.Bytes()
 }
 
-// 根据分片group生成数据交换文件,并写入指定文件夹
-func generateSpecFileFolder(fragmentGroup [][][]byte, senderPrivateKeyFilePath, receiverPublicKeyString string, jsonParser *jsontools.JsonParser, saveDir string) (string, error) {
+// 为一个接收方生成其能够解密的头部/对称密钥/Nonce/签名
+func generateReceiverSlot(headerBytes, keyBytes, nonce, fragmentBytes []byte, senderPrivateKey *rsa.PrivateKey, receiverPublicKey *rsa.PublicKey) (encryptedHeaderBytes, encryptedKeyBytes, encryptedNonce, encryptedSign []byte, err error) {
+	sign, err := rsatools.Sign(bytesCombine(headerBytes, keyBytes, nonce, fragmentBytes), senderPrivateKey)
+	if err != nil {
+		return
+	}
+	encryptedHeaderBytes, err = rsatools.EncryptWithPublicKey(headerBytes, receiverPublicKey)
+	if err != nil {
+		return
+	}
+	encryptedKeyBytes, err = rsatools.EncryptWithPublicKey(keyBytes, receiverPublicKey)
+	if err != nil {
+		return
+	}
+	encryptedNonce, err = rsatools.EncryptWithPublicKey(nonce, receiverPublicKey)
+	if err != nil {
+		return
+	}
+	encryptedSign, err = rsatools.EncryptWithPublicKey(sign, receiverPublicKey)
+	return
+}
+
+// 读取发送方本地的清单,不存在时新建一个
+func readManifest(manifestPath string) (*jsontools.JsonParser, error) {
+	if !filetools.IsPathExists(manifestPath) {
+		manifestParser := jsontools.GenerateNewJsonParser()
+		manifestParser.SetArray("FileList")
+		return manifestParser, nil
+	}
+	return jsontools.ReadJsonFile(manifestPath)
+}
+
+// 读取发送方本地的清单,返回数据交换文件名对应所属组的Map,组由Identification和GroupSN共同确定
+func ReadManifestGroupMap(specFileFolderDir string) (map[string]string, error) {
+	fileName_GroupMap := make(map[string]string)
+	manifestParser, err := readManifest(filepath.Join(specFileFolderDir, ManifestFileName))
+	if err != nil {
+		return nil, err
+	}
+	for _, children := range manifestParser.GetAllChildren("FileList") {
+		fileName_GroupMap[children.ReadJsonValue("/Name").(string)] = children.ReadJsonValue("/Group").(string)
+	}
+	return fileName_GroupMap, nil
+}
+
+// 从发送阶段配置中读取填充策略,没有配置时使用原有的随机填充
+func ReadPaddingPolicy(jsonParser *jsontools.JsonParser) padding.Policy {
+	var policy padding.Policy
+	if jsonParser.IsPathExists("/PaddingPolicy") {
+		policy.Type = padding.PaddingPolicy(jsonParser.ReadJsonValue("/PaddingPolicy").(string))
+	}
+	if jsonParser.IsPathExists("/PaddingSizeClassList") {
+		for _, sizeClass := range jsonParser.ReadJsonValue("/PaddingSizeClassList").([]interface{}) {
+			policy.SizeClassList = append(policy.SizeClassList, int(sizeClass.(float64)))
+		}
+	}
+	if jsonParser.IsPathExists("/PaddingConstantSize") {
+		policy.ConstantSize = int(jsonParser.ReadJsonValue("/PaddingConstantSize").(float64))
+	}
+	return policy
+}
+
+// 计算填充之前数据交换文件的长度
+func GetSpecFileLength(fileDataLength, receiverNum int) int {
+	if receiverNum > 1 {
+		return receiverNum*getReceiverSlotLength() + aestools.GetCiphertextLength(fileDataLength)
+	}
+	return getSlotCiphertextLength() + aestools.GetCiphertextLength(fileDataLength)
+}
+
+// 根据发送阶段配置,估计一个数据交换文件填充之后的最大长度
+func EstimateMaxSpecFileLength(sendStrategyJsonParser *jsontools.JsonParser, fileDataLength int) (int, error) {
+	receiverNum := len(jsontools.ReadReceiverNameList(sendStrategyJsonParser))
+	return padding.CalculateMaxPaddedLength(ReadPaddingPolicy(sendStrategyJsonParser), GetSpecFileLength(fileDataLength, receiverNum), fileDataLength)
+}
+
+// 根据分片group生成数据交换文件,并写入指定文件夹.
+//  有多个接收方时,分片只加密一次,对称密钥分别用每个接收方的公钥加密后写入各自的槽中
+func generateSpecFileFolder(fragmentGroup [][][]byte, senderPrivateKeyFilePath string, receiverNameList, receiverPublicKeyStringList, receiverExchangePublicKeyStringList []string, jsonParser *jsontools.JsonParser, saveDir string) (string, error) {
 	var err error
 	// 获得发送方私钥字符串
 	senderPrivateKey, err := rsatools.ReadPrivateKeyFile(senderPrivateKeyFilePath)
 	if err != nil {
 		return "", err
 	}
-	receiverPublicKey, err := rsatools.StringToPublicKey(receiverPublicKeyString)
-	if err != nil {
+	var receiverPublicKeyList []*rsa.PublicKey
+	for i, receiverPublicKeyString := range receiverPublicKeyStringList {
+		if receiverPublicKeyString == "" {
+			err = fmt.Errorf("无法在用户列表中找到%s的公钥", receiverNameList[i])
+			fmt.Println(err)
+			return "", err
+		}
+		receiverPublicKey, err := rsatools.StringToPublicKey(receiverPublicKeyString)
+		if err != nil {
+			return "", err
+		}
+		receiverPublicKeyList = append(receiverPublicKeyList, receiverPublicKey)
+	}
+	receiverNum := len(receiverNameList)
+	if receiverNum == 0 || receiverNum > MaxReceiverNum {
+		err = fmt.Errorf("接收方的数量必须在1到%d之间", MaxReceiverNum)
+		fmt.Println(err)
 		return "", err
 	}
 	divideMethod := int8(jsonParser.ReadJsonValue("/DivideMethod").(float64))
 	groupNum := int8(jsonParser.ReadJsonValue("/GroupNum").(float64))
 	maxNumInAGroup := fragment.CalculateMaxNumInAGroup(int(divideMethod), int(groupNum))
 	senderName := jsonParser.ReadJsonValue("/SenderName").(string)
-	receiverName := jsonParser.ReadJsonValue("/ReceiverName").(string)
 	_, fileName := filepath.Split(jsonParser.ReadJsonValue("/SrcFilePath").(string))
 	identification := int32(jsonParser.ReadJsonValue("/Identification").(float64))
 	fileDataLength := int32(jsonParser.ReadJsonValue("/FileDataLength").(float64))
-	// 以receiverName作为存储数据交换文件的文件夹
-	specFileFolderName := receiverName
+	// 以receiverName作为存储数据交换文件的文件夹,多个接收方时用分隔符连接
+	specFileFolderName := strings.Join(receiverNameList, jsontools.ReceiverNameSeparator)
 	timer := int32(jsonParser.ReadJsonValue("/Timer").(float64))
-	for i := 0; i < len(fragmentGroup); i++ {
-		fragmentNumInGroup := len(fragmentGroup[i])
-		for j := 0; j < fragmentNumInGroup; j++ {
-			var groupSN = int8(i)
-			var groupContent []int8
-			for k := 0; k < fragmentNumInGroup-1; k++ {
-				groupContent = append(groupContent, int8(i*maxNumInAGroup+k))
-			}
-			var isRedundant = bool(j+1 == fragmentNumInGroup) // 如果是组中最后一个分片,那就是冗余分片
-			var fragmentSN int8
-			if isRedundant {
-				fragmentSN = -1
-			} else {
-				fragmentSN = int8(i*maxNumInAGroup + j)
-			}
-			headerBytes, err := header.GenerateHeaderBytes(senderName, receiverName, fileName, identification, fileDataLength, timer, divideMethod, groupNum, groupSN, fragmentSN, groupContent)
-			if err != nil {
-				return "", err
-			}
-			aesKey, nonce, err := aestools.InitAES()
-			padding := padding.GeneratePadding(int(fileDataLength / 3))
-			sign, err := rsatools.Sign(bytesCombine(headerBytes, aesKey, nonce, fragmentGroup[i][j]), senderPrivateKey)
-			if err != nil {
-				return "", err
-			}
-			encryptedHeaderBytes, err := rsatools.EncryptWithPublicKey(headerBytes, receiverPublicKey)
+	paddingPolicy := ReadPaddingPolicy(jsonParser)
+	// 前向安全模式下,为本次通信生成一个临时X25519密钥对,并与每个接收方的交换公钥分别协商出共享密钥
+	keyExchange := header.KEY_EXCHANGE_RSA
+	var ephemeralPublicKey []byte
+	receiverExchangePublicKeyList := make([][]byte, receiverNum)
+	sharedSecretList := make([][]byte, receiverNum)
+	if jsonParser.IsPathExists("/ForwardSecrecy") && jsonParser.ReadJsonValue("/ForwardSecrecy").(bool) {
+		keyExchange = header.KEY_EXCHANGE_X25519
+		var ephemeralPrivateKey []byte
+		ephemeralPublicKey, ephemeralPrivateKey, err = x25519tools.GenerateKeyPair()
+		if err != nil {
+			return "", err
+		}
+		defer x25519tools.WipeKey(ephemeralPrivateKey) // 临时私钥用完即销毁
+		for recipientSN, receiverExchangePublicKeyString := range receiverExchangePublicKeyStringList {
+			receiverExchangePublicKeyList[recipientSN], err = x25519tools.StringToKey(receiverExchangePublicKeyString)
 			if err != nil {
+				fmt.Println("接收方", receiverNameList[recipientSN], "没有发布可用的交换公钥,无法使用前向安全模式")
 				return "", err
 			}
-			encryptedAesKey, err := rsatools.EncryptWithPublicKey(aesKey, receiverPublicKey)
+			sharedSecretList[recipientSN], err = x25519tools.ComputeSharedSecret(ephemeralPrivateKey, receiverExchangePublicKeyList[recipientSN])
 			if err != nil {
 				return "", err
 			}
-			encryptedNonce, err := rsatools.EncryptWithPublicKey(nonce, receiverPublicKey)
+			defer x25519tools.WipeKey(sharedSecretList[recipientSN])
+		}
+	}
+	// 发送方本地的清单,记录每个数据交换文件属于哪个组,上传时据此把同一组的文件分散到不同的IFSS账号
+	manifestPath := filepath.Join(saveDir, specFileFolderName, ManifestFileName)
+	manifestParser, err := readManifest(manifestPath)
+	if err != nil {
+		return "", err
+	}
+	// 每个分片的加密和写入是一个任务,由工作池并行完成
+	type fragmentTask struct{ i, j int }
+	var taskList []fragmentTask
+	for i := 0; i < len(fragmentGroup); i++ {
+		for j := 0; j < len(fragmentGroup[i]); j++ {
+			taskList = append(taskList, fragmentTask{i, j})
+		}
+	}
+	specFileNameList := make([]string, len(taskList))
+	err = workerpool.Run(workerpool.ReadWorkerNum(jsonParser), len(taskList), func(t int) error {
+		i, j := taskList[t].i, taskList[t].j
+		fragmentNumInGroup := len(fragmentGroup[i])
+		var groupSN = int8(i)
+		var groupContent []int8
+		for k := 0; k < fragmentNumInGroup-1; k++ {
+			groupContent = append(groupContent, int8(i*maxNumInAGroup+k))
+		}
+		var isRedundant = bool(j+1 == fragmentNumInGroup) // 如果是组中最后一个分片,那就是冗余分片
+		var fragmentSN int8
+		if isRedundant {
+			fragmentSN = -1
+		} else {
+			fragmentSN = int8(i*maxNumInAGroup + j)
+		}
+		aesKey, nonce, err := aestools.InitAES()
+		if err != nil {
+			return err
+		}
+		encryptedFragmentBytes, err := aestools.EncryptWithAES(aesKey, nonce, fragmentGroup[i][j])
+		if err != nil {
+			return err
+		}
+		var specFileBytes []byte
+		for recipientSN, receiverName := range receiverNameList {
+			headerBytes, err := header.GenerateHeaderBytes(senderName, receiverName, fileName, identification, fileDataLength, timer, divideMethod, groupNum, groupSN, fragmentSN, groupContent, keyExchange, int8(receiverNum), int8(recipientSN))
 			if err != nil {
-				return "", err
+				return err
 			}
-			encryptedFragmentBytes, err := aestools.EncryptWithAES(aesKey, nonce, fragmentGroup[i][j])
-			if err != nil {
-				return "", err
+			// 对称密钥段中写入的内容,前向安全模式下写入临时公钥/交换公钥ID/用该接收方的派生密钥包装过的对称密钥
+			keyBytes := aesKey
+			if keyExchange == header.KEY_EXCHANGE_X25519 {
+				receiverExchangePublicKey := receiverExchangePublicKeyList[recipientSN]
+				derivedKey, err := x25519tools.DeriveFragmentKey(sharedSecretList[recipientSN], ephemeralPublicKey, receiverExchangePublicKey, identification, groupSN, fragmentSN)
+				if err != nil {
+					return err
+				}
+				keyBytes = bytesCombine(ephemeralPublicKey, x25519tools.GetKeyID(receiverExchangePublicKey), x25519tools.XorKey(aesKey, derivedKey))
+				x25519tools.WipeKey(derivedKey)
 			}
-			encryptedSign, err := rsatools.EncryptWithPublicKey(sign, receiverPublicKey)
+			encryptedHeaderBytes, encryptedAesKey, encryptedNonce, encryptedSign, err := generateReceiverSlot(headerBytes, keyBytes, nonce, fragmentGroup[i][j], senderPrivateKey, receiverPublicKeyList[recipientSN])
 			if err != nil {
-				return "", err
-			}
-			specFileBytes := bytesCombine(encryptedHeaderBytes, encryptedAesKey, encryptedNonce, encryptedFragmentBytes, encryptedSign, padding)
-			// 随机为数据交换文件分配一个9位的随机字符串文件名
-			var specFileName string
-			var seed = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
-			b := bytes.NewBufferString(seed)
-			for i := 0; i < 9; i++ {
-				randomInt, _ := rand.Int(rand.Reader, big.NewInt(int64(b.Len())))
-				specFileName += string(seed[randomInt.Int64()])
+				return err
 			}
-			filePath := filepath.Join(saveDir, specFileFolderName, specFileName)
-			err = filetools.WriteFile(filePath, specFileBytes, 0755)
-			if err != nil {
-				return "", err
+			if receiverNum == 1 {
+				specFileBytes = bytesCombine(encryptedHeaderBytes, encryptedAesKey, encryptedNonce, encryptedFragmentBytes, encryptedSign)
+			} else {
+				keyHint, err := generateKeyHint(receiverPublicKeyList[recipientSN], encryptedHeaderBytes)
+				if err != nil {
+					return err
+				}
+				specFileBytes = bytesCombine(specFileBytes, encryptedHeaderBytes, encryptedAesKey, encryptedNonce, encryptedSign, keyHint)
 			}
 		}
+		if receiverNum > 1 {
+			specFileBytes = bytesCombine(specFileBytes, encryptedFragmentBytes)
+		}
+		// 根据填充策略在数据交换文件末尾追加填充
+		paddingLength, err := padding.CalculatePaddingLength(paddingPolicy, len(specFileBytes), int(fileDataLength))
+		if err != nil {
+			return err
+		}
+		specFileBytes = bytesCombine(specFileBytes, padding.GeneratePaddingWithLength(paddingLength))
+		// 随机为数据交换文件分配一个9位的随机字符串文件名
+		specFileName := filetools.GenerateRandomFileName(filetools.RandomFileNameLength)
+		filePath := filepath.Join(saveDir, specFileFolderName, specFileName)
+		err = filetools.WriteFile(filePath, specFileBytes, 0755)
+		if err != nil {
+			return err
+		}
+		specFileNameList[t] = specFileName
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	for t, specFileName := range specFileNameList {
+		manifest := jsontools.GenerateNewJsonParser()
+		manifest.SetValue(specFileName, "Name")
+		manifest.SetValue(strconv.Itoa(int(identification))+"-"+strconv.Itoa(taskList[t].i), "Group")
+		manifestParser.AppendArray(manifest.Parser.Data(), "FileList")
+	}
+	err = manifestParser.WriteJsonFile(manifestPath)
+	if err != nil {
+		return "", err
 	}
 	return filepath.Join(saveDir, specFileFolderName), err
 }
 
 // 从加密过的文件中读取出未加密的fragment
-func generateUnencryptedFragmentBytes(fileInfo FileInfo, receiverPrivateKeyFilePath string, senderPublicKeyString string) ([]byte, error) {
+func generateUnencryptedFragmentBytes(fileInfo FileInfo, receiverExchangeKeyRing *x25519tools.KeyRing, senderPublicKeyString string) ([]byte, error) {
 	var err error
-	receiverPrivateKey, err := rsatools.ReadPrivateKeyFile(receiverPrivateKeyFilePath)
+	receiverPrivateKey := fileInfo.ReceiverPrivateKey
 	// 获得公钥
 	senderPublicKey, err := rsatools.StringToPublicKey(senderPublicKeyString)
 	if err != nil {
@@ -211,10 +490,18 @@ func generateUnencryptedFragmentBytes(fileInfo FileInfo, receiverPrivateKeyFileP
 	}
 	encryptedAesKey := make([]byte, fileInfo.EncryptedFileStructure.SymmetricKeyStructure.Length)
 	f.ReadAt(encryptedAesKey, int64(fileInfo.EncryptedFileStructure.SymmetricKeyStructure.Start))
-	unencryptedAesKey, err := rsatools.DecryptWithPrivateKey(encryptedAesKey, receiverPrivateKey)
+	unencryptedKeyBytes, err := rsatools.DecryptWithPrivateKey(encryptedAesKey, receiverPrivateKey)
 	if err != nil {
 		return nil, err
 	}
+	unencryptedAesKey := unencryptedKeyBytes
+	// 前向安全模式下,对称密钥段中是发送方的临时公钥和包装过的对称密钥,需要用本方的交换私钥协商后解开
+	if fileInfo.Header.GetKeyExchange() == header.KEY_EXCHANGE_X25519 {
+		unencryptedAesKey, err = unwrapFragmentKey(fileInfo.Header, unencryptedKeyBytes, receiverExchangeKeyRing)
+		if err != nil {
+			return nil, err
+		}
+	}
 	encryptedNonce := make([]byte, fileInfo.EncryptedFileStructure.NonceStructure.Length)
 	f.ReadAt(encryptedNonce, int64(fileInfo.EncryptedFileStructure.NonceStructure.Start))
 	unencryptedNonce, err := rsatools.DecryptWithPrivateKey(encryptedNonce, receiverPrivateKey)
@@ -234,20 +521,73 @@ func generateUnencryptedFragmentBytes(fileInfo FileInfo, receiverPrivateKeyFileP
 		return nil, err
 	}
 	// 数据验签
-	if rsatools.Verify(bytesCombine(unencryptedHeaderBytes, unencryptedAesKey, unencryptedNonce, unencryptedFragmentBytes), unencryptedSign, senderPublicKey) != nil {
+	if rsatools.Verify(bytesCombine(unencryptedHeaderBytes, unencryptedKeyBytes, unencryptedNonce, unencryptedFragmentBytes), unencryptedSign, senderPublicKey) != nil {
 		fmt.Println("数据验签无法通过")
+		err = fmt.Errorf("数据验签无法通过")
 		return nil, err
 	}
 	return unencryptedFragmentBytes, err
 }
 
-// 生成FragmentSN对应UnencryptedFragmentBytes的Map
-func generateFragmentSN_UnencryptedFragmentBytesMap(groupSN_GroupInfoMap map[int]GroupInfo, fragmentSN_DataFileInfoMap *map[int]FileInfo, receiverPrivateKeyFilePath string, senderPublicKeyString string) (map[int][]byte, error) {
+// 对称密钥段的内容为临时公钥|交换公钥ID|包装过的对称密钥,根据交换公钥ID在密钥环中找到本方的交换私钥,
+//  与临时公钥协商并派生出该分片的密钥,再解开对称密钥
+func unwrapFragmentKey(h header.Header, keyBytes []byte, receiverExchangeKeyRing *x25519tools.KeyRing) ([]byte, error) {
 	var err error
-	groupSN_UnencryptedFragmentBytesMap := make(map[int][]byte)
+	if receiverExchangeKeyRing == nil {
+		err = fmt.Errorf("该数据交换文件使用了前向安全模式,但没有提供交换密钥环")
+		fmt.Println(err)
+		return nil, err
+	}
+	if len(keyBytes) != x25519tools.KeySize+x25519tools.KeyIDSize+256/8 {
+		err = fmt.Errorf("前向安全模式的对称密钥段长度不合法")
+		fmt.Println(err)
+		return nil, err
+	}
+	ephemeralPublicKey := keyBytes[:x25519tools.KeySize]
+	keyID := keyBytes[x25519tools.KeySize : x25519tools.KeySize+x25519tools.KeyIDSize]
+	wrappedAesKey := keyBytes[x25519tools.KeySize+x25519tools.KeyIDSize:]
+	receiverExchangePrivateKey, err := receiverExchangeKeyRing.FindPrivateKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	receiverExchangePublicKey, err := x25519tools.GetPublicKey(receiverExchangePrivateKey)
 	if err != nil {
 		return nil, err
 	}
+	sharedSecret, err := x25519tools.ComputeSharedSecret(receiverExchangePrivateKey, ephemeralPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	defer x25519tools.WipeKey(sharedSecret)
+	derivedKey, err := x25519tools.DeriveFragmentKey(sharedSecret, ephemeralPublicKey, receiverExchangePublicKey, h.GetIdentification(), h.GetGroupSN(), h.GetFragmentSN())
+	if err != nil {
+		return nil, err
+	}
+	defer x25519tools.WipeKey(derivedKey)
+	return x25519tools.XorKey(wrappedAesKey, derivedKey), nil
+}
+
+// 使用工作池并行地从多个加密过的文件中读取出未加密的fragment
+func generateUnencryptedFragmentBytesList(fileInfoList []FileInfo, receiverExchangeKeyRing *x25519tools.KeyRing, senderPublicKeyString string) ([][]byte, error) {
+	unencryptedFragmentBytesList := make([][]byte, len(fileInfoList))
+	err := workerpool.Run(workerpool.DefaultWorkerNum(), len(fileInfoList), func(i int) error {
+		var err error
+		unencryptedFragmentBytesList[i], err = generateUnencryptedFragmentBytes(fileInfoList[i], receiverExchangeKeyRing, senderPublicKeyString)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return unencryptedFragmentBytesList, err
+}
+
+// 生成FragmentSN对应UnencryptedFragmentBytes的Map
+func generateFragmentSN_UnencryptedFragmentBytesMap(groupSN_GroupInfoMap map[int]GroupInfo, fragmentSN_DataFileInfoMap *map[int]FileInfo, receiverExchangeKeyRing *x25519tools.KeyRing, senderPublicKeyString string) (map[int][]byte, error) {
+	var err error
+	groupSN_UnencryptedFragmentBytesMap := make(map[int][]byte)
+	// 先检查每一组能否还原,并找出需要解密的文件,所有组的文件一起并行解密
+	var decryptFileInfoList []FileInfo
+	groupSN_LostDataFragmentSNMap := make(map[int]int) // 只丢失了一个数据分片的组中丢失的分片
 	for groupSN := range groupSN_GroupInfoMap {
 		groupInfo := groupSN_GroupInfoMap[groupSN]
 		acturalGroupTotal := len(groupInfo.DataFileInfoList)
@@ -285,65 +625,87 @@ func generateFragmentSN_UnencryptedFragmentBytesMap(groupSN_GroupInfoMap map[int
 						lostDataFragmentSN = int(expectedFragmentSN)
 					}
 				}
-				var restoreGroup [][]byte
-				for _, fileInfo := range append(groupInfo.DataFileInfoList, groupInfo.RedundanceFileInfo) {
-					unencryptedFragmentBytes, err := generateUnencryptedFragmentBytes(fileInfo, receiverPrivateKeyFilePath, senderPublicKeyString)
-					if err != nil {
-						return nil, err
-					}
-					restoreGroup = append(restoreGroup, unencryptedFragmentBytes)
-					fragmentSN := int(fileInfo.Header.GetFragmentSN())
-					var isRedundant = bool(fragmentSN == -1)
-					if isRedundant == false {
-						groupSN_UnencryptedFragmentBytesMap[fragmentSN] = unencryptedFragmentBytes
-					}
-				}
-				lostDataFragmentBytes := redundance.RestoreLostFragment(restoreGroup[:len(restoreGroup)-2], restoreGroup[len(restoreGroup)-1])
-				groupSN_UnencryptedFragmentBytesMap[lostDataFragmentSN] = lostDataFragmentBytes
+				groupSN_LostDataFragmentSNMap[groupSN] = lostDataFragmentSN
+				decryptFileInfoList = append(decryptFileInfoList, groupInfo.DataFileInfoList...)
+				decryptFileInfoList = append(decryptFileInfoList, groupInfo.RedundanceFileInfo)
 			}
 		} else if expectedGroupTotal == acturalGroupTotal { // 数据分片已经收齐
-			for _, fileInfo := range groupInfo.DataFileInfoList {
-				unencryptedFragmentBytes, err := generateUnencryptedFragmentBytes(fileInfo, receiverPrivateKeyFilePath, senderPublicKeyString)
-				if err != nil {
-					return nil, err
-				}
-				fragmentSN := int(fileInfo.Header.GetFragmentSN())
-				groupSN_UnencryptedFragmentBytesMap[fragmentSN] = unencryptedFragmentBytes
-			}
+			decryptFileInfoList = append(decryptFileInfoList, groupInfo.DataFileInfoList...)
+		}
+	}
+	unencryptedFragmentBytesList, err := generateUnencryptedFragmentBytesList(decryptFileInfoList, receiverExchangeKeyRing, senderPublicKeyString)
+	if err != nil {
+		return nil, err
+	}
+	groupSN_RestoreGroupMap := make(map[int][][]byte)          // 丢失了数据分片的组中剩下的数据分片
+	groupSN_RedundanceFragmentBytesMap := make(map[int][]byte) // 丢失了数据分片的组的冗余分片
+	for i, fileInfo := range decryptFileInfoList {
+		groupSN := int(fileInfo.Header.GetGroupSN())
+		fragmentSN := int(fileInfo.Header.GetFragmentSN())
+		var isRedundant = bool(fragmentSN == -1)
+		if isRedundant == false {
+			groupSN_UnencryptedFragmentBytesMap[fragmentSN] = unencryptedFragmentBytesList[i]
+		}
+		if _, isLost := groupSN_LostDataFragmentSNMap[groupSN]; !isLost {
+			continue
+		}
+		if isRedundant {
+			groupSN_RedundanceFragmentBytesMap[groupSN] = unencryptedFragmentBytesList[i]
+		} else {
+			groupSN_RestoreGroupMap[groupSN] = append(groupSN_RestoreGroupMap[groupSN], unencryptedFragmentBytesList[i])
+		}
+	}
+	// 用组内剩下的所有数据分片和冗余分片还原丢失的数据分片
+	for groupSN, lostDataFragmentSN := range groupSN_LostDataFragmentSNMap {
+		redundanceFragmentBytes, isExist := groupSN_RedundanceFragmentBytesMap[groupSN]
+		if !isExist {
+			err = fmt.Errorf("无法还原,组内数据分片丢失且冗余分片丢失")
+			fmt.Println(err)
+			return nil, err
 		}
+		lostDataFragmentBytes := redundance.RestoreLostFragment(groupSN_RestoreGroupMap[groupSN], redundanceFragmentBytes)
+		groupSN_UnencryptedFragmentBytesMap[lostDataFragmentSN] = lostDataFragmentBytes
 	}
 	return groupSN_UnencryptedFragmentBytesMap, err
 }
 
 // 根据当前待还原文件夹中的数据交换文件列表还原出来文件,并存在fileSavePath里面
-func restoreFromFilePathList(fileSaveDir string, filePathList []string, receiverPrivateKeyFilePath string, userListParser *jsontools.JsonParser, restoreProgressChannel chan []byte) error {
+func restoreFromFilePathList(fileSaveDir string, filePathList []string, receiverPrivateKeyFilePath, receiverExchangeKeyRingDir string, userListParser *jsontools.JsonParser, keyPinStorePath string, restoreProgressChannel chan []byte) error {
 	var err error
-	receiverPrivateKey, err := rsatools.ReadPrivateKeyFile(receiverPrivateKeyFilePath)
+	receiverPrivateKeyList, err := readReceiverPrivateKeyList(receiverPrivateKeyFilePath, userListParser)
 	if err != nil {
 		return err
 	}
+	// 交换密钥环在每次还原时只读取一次,没有提供时只能还原非前向安全模式的数据交换文件
+	var receiverExchangeKeyRing *x25519tools.KeyRing
+	if receiverExchangeKeyRingDir != "" {
+		receiverExchangeKeyRing, err = x25519tools.ReadKeyRing(receiverExchangeKeyRingDir)
+		if err != nil {
+			return err
+		}
+		defer receiverExchangeKeyRing.Wipe()
+	}
 	groupSN_GroupInfoMap := make(map[int]GroupInfo)
 	fragmentSN_DataFileInfoMap := make(map[int]FileInfo)
 	// 获得发送方公钥字符串
 	var senderPublicKeyString string
-	for _, filePath := range filePathList {
-		f, err := os.Open(filePath)
-		if err != nil {
-			fmt.Println("无法打开数据交换文件", filePath)
-			return err
-		}
-		// 读取头部,并加入map中
-		encryptedHeaderBytes := make([]byte, rsatools.GetCiphertextLength(header.GetHeaderBytesSize()))
-		f.ReadAt(encryptedHeaderBytes, 0) // 将头部读取到headerBytes里面
-		f.Close()
-		unencryptedHeaderBytes, err := rsatools.DecryptWithPrivateKey(encryptedHeaderBytes, receiverPrivateKey)
-		if err != nil {
-			return err
-		}
-		header, err := header.BytesToHeader(unencryptedHeaderBytes)
+	// 并行读取所有文件的头部
+	fileInfoList := make([]FileInfo, len(filePathList))
+	err = workerpool.Run(workerpool.DefaultWorkerNum(), len(filePathList), func(i int) error {
+		header, receiverPrivateKey, err := readHeaderFromSpecFile(filePathList[i], receiverPrivateKeyList)
 		if err != nil {
 			return err
 		}
+		unencryptedFileStructure, encryptedFileStructure := generateFileStructure(header)
+		fileInfoList[i] = FileInfo{filePathList[i], unencryptedFileStructure, encryptedFileStructure, header, receiverPrivateKey}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, fileInfo := range fileInfoList {
+		// 把头部加入map中
+		header := fileInfo.Header
 		senderName := header.GetSenderName()
 		if senderPublicKeyString == "" {
 			for _, children := range userListParser.GetAllChildren("UserList") {
@@ -355,8 +717,6 @@ func restoreFromFilePathList(fileSaveDir string, filePathList []string, receiver
 		}
 		fragmentSN := int(header.GetFragmentSN())
 		groupSN := int(header.GetGroupSN())
-		unencryptedFileStructure, encryptedFileStructure := generateFileStructure(header)
-		fileInfo := FileInfo{filePath, unencryptedFileStructure, encryptedFileStructure, header}
 		if fragmentSN != -1 { // 是数据分片的话
 			groupSN_GroupInfoMap[groupSN] = GroupInfo{append(groupSN_GroupInfoMap[groupSN].DataFileInfoList, fileInfo), groupSN_GroupInfoMap[groupSN].RedundanceFileInfo}
 			fragmentSN_DataFileInfoMap[fragmentSN] = fileInfo
@@ -380,13 +740,31 @@ func restoreFromFilePathList(fileSaveDir string, filePathList []string, receiver
 	divideMethod := int(firstDataFileHeader.GetDivideMethod())
 	groupNum := int(firstDataFileHeader.GetGroupNum())
 	successReceiveNum := len(filePathList)
+	// 检查发送方公钥是否与之前记录的指纹一致
+	senderKeyPinState, senderKeyFingerprint, err := keypin.CheckAndPinString(keyPinStorePath, senderName, senderPublicKeyString)
+	if err != nil {
+		return err
+	}
+	senderKeyFingerprintEmoji, err := rsatools.GetPublicKeyStringFingerprintEmoji(senderPublicKeyString)
+	if err != nil {
+		return err
+	}
+	// 发送方的公钥发生了变化时不还原,数据交换文件保留在待还原文件夹中,
+	// 用户线下核对指纹并调用AcceptSenderKeyChange接受新的公钥之后,下一次还原时再处理
+	if senderKeyPinState == keypin.PIN_CHANGED {
+		restoreProgressJsonBytes := jsontools.GenerateRestoreProgressJsonBytes(dstAbsFilePath, senderName, receiverName, senderKeyFingerprint, senderKeyFingerprintEmoji, string(senderKeyPinState), fileDataLength, identification, 0, divideMethod+groupNum)
+		restoreProgressChannel <- restoreProgressJsonBytes
+		err = fmt.Errorf("%s的公钥发生了变化,需要核对指纹并接受新的公钥之后才能还原", senderName)
+		fmt.Println(err)
+		return err
+	}
 	// 告知前端当前组装进度
 	if (successReceiveNum < divideMethod+groupNum) {
-		restoreProgressJsonBytes := jsontools.GenerateRestoreProgressJsonBytes(dstAbsFilePath, senderName, receiverName, fileDataLength, identification, successReceiveNum, divideMethod+groupNum)
+		restoreProgressJsonBytes := jsontools.GenerateRestoreProgressJsonBytes(dstAbsFilePath, senderName, receiverName, senderKeyFingerprint, senderKeyFingerprintEmoji, string(senderKeyPinState), fileDataLength, identification, successReceiveNum, divideMethod+groupNum)
 		restoreProgressChannel <- restoreProgressJsonBytes
 	}
 	// 对丢失的数据分片进行还原,并读取所有的数据分片
-	fragmentSN_UnencryptedFragmentBytesMap, err := generateFragmentSN_UnencryptedFragmentBytesMap(groupSN_GroupInfoMap, &fragmentSN_DataFileInfoMap, receiverPrivateKeyFilePath, senderPublicKeyString)
+	fragmentSN_UnencryptedFragmentBytesMap, err := generateFragmentSN_UnencryptedFragmentBytesMap(groupSN_GroupInfoMap, &fragmentSN_DataFileInfoMap, receiverExchangeKeyRing, senderPublicKeyString)
 	if err != nil {
 		return err
 	}
@@ -415,7 +793,7 @@ func restoreFromFilePathList(fileSaveDir string, filePathList []string, receiver
 	if filetools.IsPathExists(fileSavePath) {
 		// 当前还原进度为100%
 		fmt.Println("已经还原出文件", fileSavePath)
-		restoreProgressJsonBytes := jsontools.GenerateRestoreProgressJsonBytes(dstAbsFilePath, senderName, receiverName, fileDataLength, identification, 100, 100)
+		restoreProgressJsonBytes := jsontools.GenerateRestoreProgressJsonBytes(dstAbsFilePath, senderName, receiverName, senderKeyFingerprint, senderKeyFingerprintEmoji, string(senderKeyPinState), fileDataLength, identification, 100, 100)
 		restoreProgressChannel <- restoreProgressJsonBytes
 	}
 	return err
@@ -442,27 +820,38 @@ func GenerateSpecFileFolder(userListJsonPath, senderPrivateKeyFilePath string, s
 			return "", err
 		}
 	}
-	receiverName := sendStrategyJsonParser.ReadJsonValue("/ReceiverName").(string)
+	receiverNameList := jsontools.ReadReceiverNameList(sendStrategyJsonParser)
 	userListParser, err := jsontools.ReadJsonFile(userListJsonPath)
 	if err != nil {
 		return "", err
 	}
-	// 获得接收方公钥字符串
-	var receiverPublicKeyString string
-	for _, children := range userListParser.GetAllChildren("UserList") {
-		name := children.ReadJsonValue("/Name").(string)
-		if name == receiverName {
-			receiverPublicKeyString = children.ReadJsonValue("/PublicKey").(string)
-			break
+	// 获得每个接收方的公钥字符串,以及每个接收方发布的交换公钥(用于前向安全模式)
+	receiverPublicKeyStringList := make([]string, len(receiverNameList))
+	receiverExchangePublicKeyStringList := make([]string, len(receiverNameList))
+	for i, receiverName := range receiverNameList {
+		for _, children := range userListParser.GetAllChildren("UserList") {
+			name := children.ReadJsonValue("/Name").(string)
+			if name == receiverName {
+				receiverPublicKeyStringList[i] = children.ReadJsonValue("/PublicKey").(string)
+				if children.IsPathExists("/ExchangePublicKey") {
+					receiverExchangePublicKeyStringList[i], _ = children.ReadJsonValue("/ExchangePublicKey").(string)
+				}
+				break
+			}
+		}
+		// 接收方不是个人时,再到组列表中寻找组公钥
+		if receiverPublicKeyStringList[i] == "" {
+			receiverPublicKeyStringList[i] = grouptools.FindGroupPublicKeyString(userListParser, receiverName)
 		}
 	}
 	// 为所有分片添加签名/对称密钥/头部/无意义填充,使之生成数据交换文件,并写入文件夹
-	sendDir, err := generateSpecFileFolder(fragmentGroup, senderPrivateKeyFilePath, receiverPublicKeyString, sendStrategyJsonParser, saveDir)
+	sendDir, err := generateSpecFileFolder(fragmentGroup, senderPrivateKeyFilePath, receiverNameList, receiverPublicKeyStringList, receiverExchangePublicKeyStringList, sendStrategyJsonParser, saveDir)
 	return sendDir, err
 }
 
-// 从数据交换文件的文件夹中恢复出要传输的文件,并将文件存储在fileSaveDir中
-func RestoreFromSpecFileFolder(fileSaveDir, receiverPrivateKeyFilePath, userListJsonPath, specFileFoldeDir string, task_RecordMap *sync.Map, restoreProgressChannel chan []byte) error {
+// 从数据交换文件的文件夹中恢复出要传输的文件,并将文件存储在fileSaveDir中.
+//  receiverExchangeKeyRingDir为本方的交换密钥环(见x25519tools.RotateKeyRing),还原后销毁其中已经过期的交换私钥
+func RestoreFromSpecFileFolder(fileSaveDir, receiverPrivateKeyFilePath, receiverExchangeKeyRingDir, userListJsonPath, keyPinStorePath, specFileFoldeDir string, task_RecordMap *sync.Map, restoreProgressChannel chan []byte) error {
 	var err error
 	_, identification := filepath.Split(specFileFoldeDir)
 	filePathList, _, err := filetools.GenerateUnhiddenFilePathNameListFromFolder(specFileFoldeDir)
@@ -474,10 +863,16 @@ func RestoreFromSpecFileFolder(fileSaveDir, receiverPrivateKeyFilePath, userList
 		return err
 	}
 	// sortedFragmentBytesList, fileSavePath, err := restoreFromFilePathList(fileSaveDir, filePathList, receiverPrivateKeyFilePath, userListParser, restoreProgressChannel)
-	err = restoreFromFilePathList(fileSaveDir, filePathList, receiverPrivateKeyFilePath, userListParser, restoreProgressChannel)
+	err = restoreFromFilePathList(fileSaveDir, filePathList, receiverPrivateKeyFilePath, receiverExchangeKeyRingDir, userListParser, keyPinStorePath, restoreProgressChannel)
 	if err != nil {
 		return err
 	}
+	if receiverExchangeKeyRingDir != "" {
+		err = x25519tools.DestroyRetiredKeys(receiverExchangeKeyRingDir, x25519tools.RetiredKeyLifetime)
+		if err != nil {
+			return err
+		}
+	}
 	// // 还原出来的最终文件的存储位置即为fileSavePath
 	// err = fragment.RestoreByFragmentList(fileSavePath, sortedFragmentBytesList)
 	// if err != nil {
@@ -494,31 +889,68 @@ func RestoreFromSpecFileFolder(fileSaveDir, receiverPrivateKeyFilePath, userList
 	return err
 }
 
+// 用户线下核对发送方的新指纹之后,接受用户列表中发送方的新公钥,之后被暂停的还原可以继续进行
+func AcceptSenderKeyChange(userListJsonPath, keyPinStorePath, senderName string) error {
+	userListParser, err := jsontools.ReadJsonFile(userListJsonPath)
+	if err != nil {
+		return err
+	}
+	var senderPublicKeyString string
+	for _, children := range userListParser.GetAllChildren("UserList") {
+		if children.ReadJsonValue("/Name").(string) == senderName {
+			senderPublicKeyString = children.ReadJsonValue("/PublicKey").(string)
+			break
+		}
+	}
+	return keypin.AcceptKeyChangeString(keyPinStorePath, senderName, senderPublicKeyString)
+}
+
+// 读取文件夹中已有文件的哈希集合,同一个文件夹只读取一次
+func readFolderHashSet(key_HashSetMap map[string]map[string]bool, key, folderDir string) (map[string]bool, error) {
+	if hashSet, isExist := key_HashSetMap[key]; isExist {
+		return hashSet, nil
+	}
+	hashSet := make(map[string]bool)
+	if filetools.IsPathExists(folderDir) {
+		filePathList, _, err := filetools.GenerateUnhiddenFilePathNameListFromFolder(folderDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, filePath := range filePathList {
+			hash, err := filetools.GetFileHash(filePath)
+			if err != nil {
+				return nil, err
+			}
+			hashSet[hash] = true
+		}
+	}
+	key_HashSetMap[key] = hashSet
+	return hashSet, nil
+}
+
 // 根据identification,将从IFSS收到的文件分到"待还原"文件夹的不同文件夹中
-func DivideToIdentificationList(specFileFolderDir, receiverPrivateKeyFilePath string, restoreFolderDir string, task_RecordMap *sync.Map) error {
+func DivideToIdentificationList(specFileFolderDir, receiverPrivateKeyFilePath, userListJsonPath string, restoreFolderDir string, task_RecordMap *sync.Map) error {
 	var err error
-	receiverPrivateKey, err := rsatools.ReadPrivateKeyFile(receiverPrivateKeyFilePath)
+	userListParser, err := jsontools.ReadJsonFile(userListJsonPath)
+	if err != nil {
+		return err
+	}
+	receiverPrivateKeyList, err := readReceiverPrivateKeyList(receiverPrivateKeyFilePath, userListParser)
 	if err != nil {
 		return err
 	}
 	filePathList, _, err := filetools.GenerateUnhiddenFilePathNameListFromFolder(specFileFolderDir)
+	identification_HashSetMap := make(map[string]map[string]bool) // 每个Identification中已有的数据交换文件的哈希
+	var headerErr error
 	for _, filePath := range filePathList {
-		f, err := os.Open(filePath)
-		if err != nil {
-			fmt.Println("无法打开数据交换文件", filePath)
-			return err
-		}
-		// 读取头部,并加入map中
-		encryptedHeaderBytes := make([]byte, rsatools.GetCiphertextLength(header.GetHeaderBytesSize()))
-		f.ReadAt(encryptedHeaderBytes, 0) // 将头部读取到headerBytes里面
-		f.Close()
-		unencryptedHeaderBytes, err := rsatools.DecryptWithPrivateKey(encryptedHeaderBytes, receiverPrivateKey)
-		if err != nil {
-			return err
-		}
-		header, err := header.BytesToHeader(unencryptedHeaderBytes)
+		// 读取头部,无法读取的文件留在原处,继续处理其他文件,最后返回第一个错误
+		header, _, err := readHeaderFromSpecFile(filePath, receiverPrivateKeyList)
 		if err != nil {
-			return err
+			fmt.Println("无法读取数据交换文件的头部,跳过", filePath, err)
+			if headerErr == nil {
+				headerErr = err
+			}
+			continue
 		}
 		identification := strconv.Itoa(int(header.GetIdentification()))
 		isSuccessRestore, isExist := task_RecordMap.Load(identification) // 返回值是value, key是否存在
@@ -530,6 +962,20 @@ func DivideToIdentificationList(specFileFolderDir, receiverPrivateKeyFilePath st
 			}
 		}
 		if !isExist {
+			// 同一个数据交换文件可能以不同的文件名上传到多个账号,按内容去重,只保留一份
+			hashSet, err := readFolderHashSet(identification_HashSetMap, identification, filepath.Join(restoreFolderDir, identification))
+			if err != nil {
+				return err
+			}
+			hash, err := filetools.GetFileHash(filePath)
+			if err != nil {
+				return err
+			}
+			if hashSet[hash] {
+				filetools.RmFile(filePath)
+				continue
+			}
+			hashSet[hash] = true
 			_, fileName := filepath.Split(filePath)
 			newDir := filepath.Join(restoreFolderDir, identification, fileName)
 			err = filetools.Mkdir(filepath.Join(restoreFolderDir, identification))
@@ -539,5 +985,8 @@ func DivideToIdentificationList(specFileFolderDir, receiverPrivateKeyFilePath st
 			filetools.Rename(filePath, newDir)
 		}		
 	}
+	if headerErr != nil {
+		return headerErr
+	}
 	return err
 }
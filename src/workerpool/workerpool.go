@@ -0,0 +1,102 @@
+// 限制并发数量的工作池,用于加解密、打包和上传等可以并行的任务.
+package workerpool
+
+import (
+	"runtime"
+	"sync"
+	"xindauserbackground/src/jsontools"
+)
+
+// 没有配置WorkerNum时使用的并发数量,默认为CPU核数
+var defaultWorkerNum = runtime.NumCPU()
+var defaultWorkerNumMutex sync.RWMutex
+
+// 设置默认的并发数量,小于等于0时恢复为CPU核数
+func SetDefaultWorkerNum(workerNum int) {
+	defaultWorkerNumMutex.Lock()
+	defer defaultWorkerNumMutex.Unlock()
+	if workerNum <= 0 {
+		workerNum = runtime.NumCPU()
+	}
+	defaultWorkerNum = workerNum
+}
+
+// 获取默认的并发数量
+func DefaultWorkerNum() int {
+	defaultWorkerNumMutex.RLock()
+	defer defaultWorkerNumMutex.RUnlock()
+	return defaultWorkerNum
+}
+
+// 从配置中读取WorkerNum,没有配置时使用默认的并发数量
+func ReadWorkerNum(jsonParser *jsontools.JsonParser) int {
+	if jsonParser != nil && jsonParser.IsPathExists("/WorkerNum") {
+		if workerNum := int(jsonParser.ReadJsonValue("/WorkerNum").(float64)); workerNum > 0 {
+			return workerNum
+		}
+	}
+	return DefaultWorkerNum()
+}
+
+// 工作池,同时运行的任务数量不超过workerNum
+type Pool struct {
+	semaphore chan struct{}
+	wg        sync.WaitGroup
+	mutex     sync.Mutex
+	err       error // 第一个出错的任务返回的错误
+}
+
+// 新建一个工作池,workerNum小于等于0时使用默认的并发数量
+func NewPool(workerNum int) *Pool {
+	if workerNum <= 0 {
+		workerNum = DefaultWorkerNum()
+	}
+	return &Pool{semaphore: make(chan struct{}, workerNum)}
+}
+
+// 提交一个任务,工作池已满时阻塞直到有任务结束.已经有任务出错时不再运行新的任务
+func (p *Pool) Go(task func() error) {
+	p.semaphore <- struct{}{}
+	if p.Err() != nil {
+		<-p.semaphore
+		return
+	}
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.semaphore
+			p.wg.Done()
+		}()
+		err := task()
+		if err != nil {
+			p.mutex.Lock()
+			if p.err == nil {
+				p.err = err
+			}
+			p.mutex.Unlock()
+		}
+	}()
+}
+
+// 已经出错的任务返回的第一个错误
+func (p *Pool) Err() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.err
+}
+
+// 等待所有已提交的任务结束,返回第一个错误
+func (p *Pool) Wait() error {
+	p.wg.Wait()
+	return p.Err()
+}
+
+// 使用最多workerNum个goroutine运行taskNum个任务,task的参数为任务的序号,返回第一个错误
+func Run(workerNum, taskNum int, task func(i int) error) error {
+	p := NewPool(workerNum)
+	for i := 0; i < taskNum; i++ {
+		i := i
+		p.Go(func() error { return task(i) })
+	}
+	return p.Wait()
+}
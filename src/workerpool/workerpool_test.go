@@ -0,0 +1,120 @@
+package workerpool
+
+import (
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+	"xindauserbackground/src/jsontools"
+)
+
+func TestRun(t *testing.T) {
+	errTask := errors.New("任务出错")
+	testCaseList := []struct {
+		name          string
+		workerNum     int
+		taskNum       int
+		failedTask    int // 出错的任务序号,为-1时所有任务都成功
+		wantMaxActive int
+	}{
+		{"没有任务", 2, 0, -1, 2},
+		{"单个并发", 1, 10, -1, 1},
+		{"有限的并发", 3, 20, -1, 3},
+		{"并发多于任务", 8, 3, -1, 3},
+		{"默认并发数量", 0, 10, -1, DefaultWorkerNum()},
+		{"有任务出错", 2, 20, 5, 2},
+	}
+	for _, testCase := range testCaseList {
+		t.Run(testCase.name, func(t *testing.T) {
+			var activeNum, maxActiveNum, doneNum int32
+			err := Run(testCase.workerNum, testCase.taskNum, func(i int) error {
+				active := atomic.AddInt32(&activeNum, 1)
+				defer atomic.AddInt32(&activeNum, -1)
+				for {
+					maxActive := atomic.LoadInt32(&maxActiveNum)
+					if active <= maxActive || atomic.CompareAndSwapInt32(&maxActiveNum, maxActive, active) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&doneNum, 1)
+				if i == testCase.failedTask {
+					return errTask
+				}
+				return nil
+			})
+			if int(maxActiveNum) > testCase.wantMaxActive {
+				t.Errorf("同时运行了%d个任务,不应超过%d个", maxActiveNum, testCase.wantMaxActive)
+			}
+			if testCase.failedTask >= 0 {
+				if err != errTask {
+					t.Errorf("返回的错误为%v,应为%v", err, errTask)
+				}
+				if int(doneNum) > testCase.taskNum {
+					t.Errorf("运行了%d个任务,多于提交的%d个", doneNum, testCase.taskNum)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("返回了错误: %v", err)
+			}
+			if int(doneNum) != testCase.taskNum {
+				t.Errorf("运行了%d个任务,应为%d个", doneNum, testCase.taskNum)
+			}
+		})
+	}
+}
+
+func TestPoolStopAfterError(t *testing.T) {
+	errTask := errors.New("任务出错")
+	p := NewPool(1)
+	var doneNum int32
+	p.Go(func() error { return errTask })
+	// 工作池只有一个位置,下一个任务要等出错的任务结束之后才能提交,因此不会再运行
+	for i := 0; i < 5; i++ {
+		p.Go(func() error {
+			atomic.AddInt32(&doneNum, 1)
+			return nil
+		})
+	}
+	if err := p.Wait(); err != errTask {
+		t.Errorf("返回的错误为%v,应为%v", err, errTask)
+	}
+	if doneNum != 0 {
+		t.Errorf("出错之后仍然运行了%d个任务", doneNum)
+	}
+}
+
+func TestReadWorkerNum(t *testing.T) {
+	defer SetDefaultWorkerNum(0)
+	SetDefaultWorkerNum(5)
+	testCaseList := []struct {
+		name       string
+		jsonString string
+		want       int
+	}{
+		{"没有配置", `{}`, 5},
+		{"配置了并发数量", `{"WorkerNum": 3}`, 3},
+		{"并发数量为0", `{"WorkerNum": 0}`, 5},
+		{"并发数量为负数", `{"WorkerNum": -2}`, 5},
+	}
+	for _, testCase := range testCaseList {
+		t.Run(testCase.name, func(t *testing.T) {
+			jsonParser, err := jsontools.ReadJsonString(testCase.jsonString)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if workerNum := ReadWorkerNum(jsonParser); workerNum != testCase.want {
+				t.Errorf("并发数量为%d,应为%d", workerNum, testCase.want)
+			}
+		})
+	}
+	if workerNum := ReadWorkerNum(nil); workerNum != 5 {
+		t.Errorf("没有配置文件时并发数量为%d,应为5", workerNum)
+	}
+	SetDefaultWorkerNum(-1)
+	if workerNum := DefaultWorkerNum(); workerNum != runtime.NumCPU() {
+		t.Errorf("恢复后的默认并发数量为%d,应为%d", workerNum, runtime.NumCPU())
+	}
+}
@@ -4,11 +4,14 @@ package ziptools
 
 import (
 	"archive/zip"
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 func addFileToZip(zipWriter *zip.Writer, filename string) error {
@@ -123,3 +126,60 @@ func UnzipFile(src string, dest string) ([]string, error) {
 	}
 	return filenames, nil
 }
+
+// 解压缩内存中的zip文件,返回文件名对应文件内容的Map,不写入磁盘
+func UnzipBytes(data []byte) (map[string][]byte, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	fileName_BytesMap := make(map[string][]byte)
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		fileBytes, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		fileName_BytesMap[f.Name] = fileBytes
+	}
+	return fileName_BytesMap, nil
+}
+
+// 在内存中把若干文件打包成一个zip文件,fileNameList和fileBytesList一一对应,不写入磁盘
+func ZipBytes(fileNameList []string, fileBytesList [][]byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	zipWriter := zip.NewWriter(&buffer)
+	for i, fileName := range fileNameList {
+		header := &zip.FileHeader{Name: fileName, Method: zip.Deflate}
+		header.Modified = time.Now()
+		writer, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		_, err = writer.Write(fileBytesList[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := zipWriter.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// 估计将若干文件打包之后zip文件的最大大小,随机数据无法压缩,deflate会有少量膨胀
+func EstimateMaxZipSize(fileSizeList ...int) int {
+	zipSize := 22 // 中央目录结束记录
+	for _, fileSize := range fileSizeList {
+		zipSize += fileSize + (fileSize/16384+1)*5 + 512 // 每个文件的本地头/中央目录头/数据描述符/文件名
+	}
+	return zipSize
+}